@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"math"
+	"math/cmplx"
+	"strconv"
+)
+
+// Metrics describes the pattern on one board of a run, so runs can be told apart without looking at them.
+type Metrics struct {
+	Generation         int
+	Mass               Cell    // total concentration of each species
+	Spots              int     // number of connected patches of predators above the threshold
+	MeanSpotSize       float64 // mean number of cells in a spot, 0 if there are none
+	DominantWavelength float64 // wavelength in cells with the most power, 0 if the predators are uniform
+	Change             float64 // largest change of a concentration since the generation before, NaN if it is not known
+	Steady             bool    // whether Change is below the tolerance
+}
+
+// MetricsWriter works out the Metrics of the boards of a run, in order, and writes them to CSV, one
+// line for each board.
+type MetricsWriter struct {
+	Threshold float64  // predator concentration above which a cell is part of a spot
+	Tolerance float64  // change per generation below which a run is steady
+	Boundary  Boundary // spots join across the edges of a Periodic board
+	w         *csv.Writer
+}
+
+// metricsHeader names the columns of the CSV written by a MetricsWriter.
+var metricsHeader = []string{"generation", "prey_mass", "predator_mass", "spots", "mean_spot_size", "dominant_wavelength", "change", "steady"}
+
+// NewMetricsWriter takes somewhere to write, the predator concentration that makes a spot, the change
+// per generation that counts as steady and the boundary of the board.
+// It returns a MetricsWriter that has written the header of the CSV.
+func NewMetricsWriter(w io.Writer, threshold, tolerance float64, boundary Boundary) (*MetricsWriter, error) {
+
+	m := &MetricsWriter{Threshold: threshold, Tolerance: tolerance, Boundary: boundary, w: csv.NewWriter(w)}
+	if err := m.w.Write(metricsHeader); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Record is a MetricsWriter method.
+// Input: the generation of a board, the board and the board of the generation just before it, which
+// is nil if there is none.
+// Return: the metrics of the board, which are also written as a line of the CSV. Successive
+// generations are compared so that a pattern that repeats itself is never taken for a steady one.
+func (m *MetricsWriter) Record(gen int, b, previous Board) (Metrics, error) {
+
+	metrics := Analyze(b, m.Threshold, m.Boundary)
+	metrics.Generation = gen
+	metrics.Change = math.NaN()
+	if previous != nil {
+		metrics.Change = MaxChange(previous, b)
+		metrics.Steady = metrics.Change < m.Tolerance
+	}
+
+	change := ""
+	if !math.IsNaN(metrics.Change) {
+		change = strconv.FormatFloat(metrics.Change, 'g', -1, 64)
+	}
+	err := m.w.Write([]string{
+		strconv.Itoa(gen),
+		strconv.FormatFloat(metrics.Mass[0], 'g', -1, 64),
+		strconv.FormatFloat(metrics.Mass[1], 'g', -1, 64),
+		strconv.Itoa(metrics.Spots),
+		strconv.FormatFloat(metrics.MeanSpotSize, 'g', -1, 64),
+		strconv.FormatFloat(metrics.DominantWavelength, 'g', -1, 64),
+		change,
+		strconv.FormatBool(metrics.Steady),
+	})
+	return metrics, err
+}
+
+// Flush is a MetricsWriter method.
+// Return: an error if the lines recorded so far can't be written out.
+func (m *MetricsWriter) Flush() error {
+	m.w.Flush()
+	return m.w.Error()
+}
+
+// Analyze takes a Board, the predator concentration above which a cell is part of a spot, and the
+// boundary of the board.
+// It returns the metrics of the board that don't depend on the boards before it.
+func Analyze(b Board, threshold float64, boundary Boundary) Metrics {
+
+	sizes := Spots(b, threshold, boundary)
+	metrics := Metrics{
+		Mass:               TotalMass(b),
+		Spots:              len(sizes),
+		DominantWavelength: DominantWavelength(b),
+	}
+	for _, size := range sizes {
+		metrics.MeanSpotSize += float64(size) / float64(len(sizes))
+	}
+	return metrics
+}
+
+// Input: a Board.
+// Return: the total concentration of each species over the board.
+func TotalMass(b Board) Cell {
+
+	var total Cell
+	for r := range b {
+		for c := range b[r] {
+			total = SumCells(total, b[r][c])
+		}
+	}
+	return total
+}
+
+// Input: two Boards of the same size.
+// Return: the largest difference between a concentration on one and the same concentration on the other.
+func MaxChange(a, b Board) float64 {
+
+	change := 0.0
+	for r := range a {
+		for c := range a[r] {
+			for species := range a[r][c] {
+				change = math.Max(change, math.Abs(a[r][c][species]-b[r][c][species]))
+			}
+		}
+	}
+	return change
+}
+
+// Spots takes a Board, a predator concentration and the boundary of the board.
+// It returns the number of cells in each spot: each patch of cells with more predators than threshold
+// that are joined through their sides. On a Periodic board the patches join across the edges too.
+func Spots(b Board, threshold float64, boundary Boundary) []int {
+
+	numRows, numCols := CountRows(b), CountCols(b)
+	_, periodic := boundary.(Periodic)
+	visited := make([][]bool, numRows)
+	for r := range visited {
+		visited[r] = make([]bool, numCols)
+	}
+
+	sizes := make([]int, 0)
+	stack := make([][2]int, 0)
+	for r := range b {
+		for c := range b[r] {
+			if visited[r][c] || b[r][c][1] <= threshold {
+				continue
+			}
+
+			// flood the spot from this cell, keeping the cells still to look around on a stack
+			size := 0
+			visited[r][c] = true
+			stack = append(stack, [2]int{r, c})
+			for len(stack) > 0 {
+				cell := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				size++
+				for _, step := range [4][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}} {
+					i, j := cell[0]+step[0], cell[1]+step[1]
+					if periodic {
+						i, j = wrapIndex(i, numRows), wrapIndex(j, numCols)
+					} else if i < 0 || i >= numRows || j < 0 || j >= numCols {
+						continue
+					}
+					if !visited[i][j] && b[i][j][1] > threshold {
+						visited[i][j] = true
+						stack = append(stack, [2]int{i, j})
+					}
+				}
+			}
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// RadialPowerSpectrum takes a Board.
+// It returns the power spectrum of its predator concentrations averaged over every direction. Entry i
+// holds the mean power of the waves with i cycles over the longer side of the board, which have a
+// wavelength of that side's length divided by i. Entry 0 is the mean itself, which is taken away
+// first so it is always 0.
+func RadialPowerSpectrum(b Board) []float64 {
+
+	numRows, numCols := CountRows(b), CountCols(b)
+	predators := make([]complex128, numRows*numCols)
+	mean := TotalMass(b)[1] / float64(numRows*numCols)
+	for r := range b {
+		for c := range b[r] {
+			predators[r*numCols+c] = complex(b[r][c][1]-mean, 0)
+		}
+	}
+	newFFT2(numRows, numCols).transform(predators, false)
+
+	// frequencies are counted in cycles over the longer side, and rounded to the nearest whole number
+	side := numRows
+	if numCols > side {
+		side = numCols
+	}
+	power := make([]float64, side/2+1)
+	count := make([]int, side/2+1)
+	for p := 0; p < numRows; p++ {
+		for q := 0; q < numCols; q++ {
+			fr := float64(signedFrequency(p, numRows)) / float64(numRows)
+			fc := float64(signedFrequency(q, numCols)) / float64(numCols)
+			bin := int(math.Round(math.Hypot(fr, fc) * float64(side)))
+			// the corners reach past the shortest wavelength the longer side can hold, so they are left out
+			if bin < len(power) {
+				power[bin] += math.Pow(cmplx.Abs(predators[p*numCols+q]), 2)
+				count[bin]++
+			}
+		}
+	}
+	for i := range power {
+		if count[i] > 0 {
+			power[i] /= float64(count[i])
+		}
+	}
+	return power
+}
+
+// Input: the index of a frequency in a transform of length n.
+// Return: the number of cycles it stands for, which is negative past the middle.
+func signedFrequency(i, n int) int {
+	if i > n/2 {
+		return i - n
+	}
+	return i
+}
+
+// DominantWavelength takes a Board.
+// It returns the wavelength in cells with the most power in the RadialPowerSpectrum of its predators,
+// which is about the distance between neighbouring spots or stripes, or 0 if the predators are uniform.
+func DominantWavelength(b Board) float64 {
+
+	spectrum := RadialPowerSpectrum(b)
+	best := 0
+	for i := 1; i < len(spectrum); i++ {
+		if spectrum[i] > spectrum[best] {
+			best = i
+		}
+	}
+	if best == 0 {
+		return 0
+	}
+	side := CountRows(b)
+	if CountCols(b) > side {
+		side = CountCols(b)
+	}
+	return float64(side) / float64(best)
+}
@@ -0,0 +1,91 @@
+package main
+
+import "errors"
+
+// Boundary decides what the diffusion kernel sees beyond the edge of the board.
+// Position is only called for positions off the board. It returns the cell of the board whose
+// concentrations the kernel sees there, or false if it sees the fixed concentrations Outside().
+type Boundary interface {
+	Position(i, j, numRows, numCols int) (int, int, bool)
+	Outside() Cell
+}
+
+// Periodic wraps the board around, so its edges touch the opposite ones as on a torus.
+type Periodic struct{}
+
+// Neumann is a zero-flux boundary. The board is mirrored across its edges, so nothing crosses them
+// and, with a kernel that is symmetric across both axes, the total of each species only changes
+// through reactions.
+type Neumann struct{}
+
+// Dirichlet holds the concentrations beyond the edges at a fixed Value. A Value of zero is the
+// original behaviour of dropping every contribution from outside the board.
+type Dirichlet struct {
+	Value Cell
+}
+
+// BoundaryNames lists the names understood by BoundaryFromName.
+var BoundaryNames = []string{"periodic", "neumann", "dirichlet"}
+
+func (Periodic) Position(i, j, numRows, numCols int) (int, int, bool) {
+	return wrapIndex(i, numRows), wrapIndex(j, numCols), true
+}
+
+func (Periodic) Outside() Cell { return Cell{} }
+
+func (Neumann) Position(i, j, numRows, numCols int) (int, int, bool) {
+	return mirrorIndex(i, numRows), mirrorIndex(j, numCols), true
+}
+
+func (Neumann) Outside() Cell { return Cell{} }
+
+func (d Dirichlet) Position(i, j, numRows, numCols int) (int, int, bool) {
+	return 0, 0, false
+}
+
+func (d Dirichlet) Outside() Cell { return d.Value }
+
+// Input: a Board, its Boundary and row/col values (i,j) off the board.
+// Return: the concentrations the diffusion kernel sees at board[i][j].
+func CellBeyond(currentBoard Board, boundary Boundary, i, j int) Cell {
+
+	row, col, onBoard := boundary.Position(i, j, CountRows(currentBoard), CountCols(currentBoard))
+	if !onBoard {
+		return boundary.Outside()
+	}
+	return currentBoard[row][col]
+}
+
+// Input: an index and the length of the axis it lies on.
+// Return: the index wrapped around onto [0, n).
+func wrapIndex(i, n int) int {
+	return ((i % n) + n) % n
+}
+
+// Input: an index and the length of the axis it lies on.
+// Return: the index reflected back onto [0, n) across the edges of the board, so -1 maps to 0 and n to n-1.
+func mirrorIndex(i, n int) int {
+	for i < 0 || i >= n {
+		if i < 0 {
+			i = -i - 1
+		} else {
+			i = 2*n - i - 1
+		}
+	}
+	return i
+}
+
+// BoundaryFromName takes the name of a boundary condition and the prey and predator concentrations
+// held beyond the edges by a dirichlet boundary.
+// It returns the matching Boundary.
+func BoundaryFromName(name string, prey, predator float64) (Boundary, error) {
+	switch name {
+	case "periodic":
+		return Periodic{}, nil
+	case "neumann":
+		return Neumann{}, nil
+	case "dirichlet":
+		return Dirichlet{Value: Cell{prey, predator}}, nil
+	}
+	return nil, errors.New("boundary must be periodic, neumann or dirichlet")
+}
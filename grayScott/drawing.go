@@ -6,6 +6,7 @@ package main
 import (
 	"canvas"
 	"image"
+	"math"
 
 	"gonum.org/v1/plot/palette/moreland"
 )
@@ -49,6 +50,13 @@ func DrawBoard(b Board, cellWidth int) image.Image {
 
 			val := predator / (predator + prey)
 
+			// an empty cell has no fraction, and models other than Gray-Scott can leave it outside [0, 1]
+			if math.IsNaN(val) || val < 0 {
+				val = 0
+			} else if val > 1 {
+				val = 1
+			}
+
 			//colorMap := palette.Reverse(moreland.Kindlmann()) // on white background
 			//colorMap := moreland.Kindlmann() // on black background
 			colorMap := moreland.SmoothBlueRed() // red-blue a la RNA seq
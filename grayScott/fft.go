@@ -0,0 +1,181 @@
+package main
+
+import (
+	"math"
+	"math/cmplx"
+)
+
+// fftPlan holds what the discrete Fourier transform of one length needs, so it is only worked out
+// once. Lengths that are powers of two use the radix-2 Cooley-Tukey algorithm. Any other length uses
+// Bluestein's algorithm, which writes its transform as a convolution of a power of two length.
+// A plan keeps a buffer to work in, so it must not transform two slices at once.
+type fftPlan struct {
+	n        int
+	twiddles []complex128 // exp(-2*pi*i*j/n) for j < n/2, for the radix-2 algorithm
+
+	// only for Bluestein's algorithm
+	inner  *fftPlan     // plan of the power of two length the convolution is taken in
+	chirp  []complex128 // exp(-pi*i*j^2/n) for j < n
+	filter []complex128 // transform of the conjugate chirp, wrapped around to the inner length
+	buffer []complex128 // room for the convolution
+}
+
+// Input: the length of the slices to transform.
+// Return: the plan to transform them with.
+func newFFTPlan(n int) *fftPlan {
+
+	p := &fftPlan{n: n}
+	if n&(n-1) == 0 {
+		p.twiddles = make([]complex128, n/2)
+		for j := range p.twiddles {
+			p.twiddles[j] = cmplx.Exp(complex(0, -2*math.Pi*float64(j)/float64(n)))
+		}
+		return p
+	}
+
+	m := 1
+	for m < 2*n-1 {
+		m <<= 1
+	}
+	p.inner = newFFTPlan(m)
+	p.chirp = make([]complex128, n)
+	p.filter = make([]complex128, m)
+	p.buffer = make([]complex128, m)
+	for j := range p.chirp {
+		// j*j is taken modulo 2n first, so the angle stays small and exact for long lengths
+		p.chirp[j] = cmplx.Exp(complex(0, -math.Pi*float64(j*j%(2*n))/float64(n)))
+		p.filter[j] = cmplx.Conj(p.chirp[j])
+		if j > 0 {
+			p.filter[m-j] = p.filter[j]
+		}
+	}
+	p.inner.transform(p.filter, false)
+	return p
+}
+
+// transform is a fftPlan method.
+// Input: a slice of the plan's length and whether to take the inverse transform.
+// Return: nothing, but x is replaced by its discrete Fourier transform, sum_j x[j]*exp(-2*pi*i*j*k/n),
+// or by its inverse, which has the opposite sign in the exponent and is divided by n.
+func (p *fftPlan) transform(x []complex128, inverse bool) {
+
+	if p.inner == nil {
+		p.radix2(x, inverse)
+	} else {
+		// the inverse transform is the conjugate of the forward transform of the conjugate
+		if inverse {
+			conjugate(x)
+		}
+		p.bluestein(x)
+		if inverse {
+			conjugate(x)
+		}
+	}
+	if inverse {
+		scale := complex(1/float64(p.n), 0)
+		for i := range x {
+			x[i] *= scale
+		}
+	}
+}
+
+// radix2 is a fftPlan method.
+// Input: a slice whose length is a power of two and whether to take the inverse transform.
+// Return: nothing, but x is replaced by its transform, which is not yet divided by n.
+func (p *fftPlan) radix2(x []complex128, inverse bool) {
+
+	n := len(x)
+	// put the values in bit-reversed order, so each pass can combine neighbouring blocks in place
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j ^= bit
+		if i < j {
+			x[i], x[j] = x[j], x[i]
+		}
+	}
+
+	for size := 2; size <= n; size <<= 1 {
+		half, step := size/2, n/size
+		for start := 0; start < n; start += size {
+			for k := 0; k < half; k++ {
+				w := p.twiddles[k*step]
+				if inverse {
+					w = cmplx.Conj(w)
+				}
+				a, b := x[start+k], x[start+k+half]*w
+				x[start+k], x[start+k+half] = a+b, a-b
+			}
+		}
+	}
+}
+
+// bluestein is a fftPlan method.
+// Input: a slice of the plan's length.
+// Return: nothing, but x is replaced by its forward transform.
+func (p *fftPlan) bluestein(x []complex128) {
+
+	for j := range p.buffer {
+		p.buffer[j] = 0
+	}
+	for j, value := range x {
+		p.buffer[j] = value * p.chirp[j]
+	}
+	p.inner.transform(p.buffer, false)
+	for j := range p.buffer {
+		p.buffer[j] *= p.filter[j]
+	}
+	p.inner.transform(p.buffer, true)
+	for k := range x {
+		x[k] = p.buffer[k] * p.chirp[k]
+	}
+}
+
+// Input: a slice of complex numbers.
+// Return: nothing, but every number is replaced by its complex conjugate.
+func conjugate(x []complex128) {
+	for i := range x {
+		x[i] = cmplx.Conj(x[i])
+	}
+}
+
+// fft2 holds the plans to transform a numRows x numCols array stored row after row.
+type fft2 struct {
+	numRows, numCols int
+	rows, cols       *fftPlan
+	column           []complex128
+}
+
+// Input: the number of rows and columns of the arrays to transform.
+// Return: the plans to transform them with.
+func newFFT2(numRows, numCols int) *fft2 {
+	return &fft2{
+		numRows: numRows,
+		numCols: numCols,
+		rows:    newFFTPlan(numCols),
+		cols:    newFFTPlan(numRows),
+		column:  make([]complex128, numRows),
+	}
+}
+
+// transform is a fft2 method.
+// Input: a numRows x numCols array stored row after row and whether to take the inverse transform.
+// Return: nothing, but data is replaced by its two-dimensional transform: every row is transformed,
+// and then every column.
+func (f *fft2) transform(data []complex128, inverse bool) {
+
+	for r := 0; r < f.numRows; r++ {
+		f.rows.transform(data[r*f.numCols:(r+1)*f.numCols], inverse)
+	}
+	for c := 0; c < f.numCols; c++ {
+		for r := range f.column {
+			f.column[r] = data[r*f.numCols+c]
+		}
+		f.cols.transform(f.column, inverse)
+		for r, value := range f.column {
+			data[r*f.numCols+c] = value
+		}
+	}
+}
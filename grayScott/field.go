@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/jpeg"
+	_ "image/png"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Field gives a parameter its own value at every cell of the board.
+type Field interface {
+	At(row, col int) float64
+}
+
+// Constant is a Field with the same value everywhere.
+type Constant float64
+
+// FieldFunc is a Field computed from the position of each cell.
+type FieldFunc func(row, col int) float64
+
+// FieldGrid is a Field that stores a value for every cell, such as one loaded from a CSV file or an image.
+type FieldGrid [][]float64
+
+// GrayScottField is the Gray-Scott model with feed and kill rates that vary across the board.
+type GrayScottField struct {
+	Feed Field
+	Kill Field
+}
+
+func (c Constant) At(row, col int) float64 { return float64(c) }
+
+func (f FieldFunc) At(row, col int) float64 { return f(row, col) }
+
+func (g FieldGrid) At(row, col int) float64 { return g[row][col] }
+
+func (m GrayScottField) Change(currentCell Cell, row, col int) Cell {
+	return ChangeDueToReactions(currentCell, m.Feed.At(row, col), m.Kill.At(row, col))
+}
+
+// PearsonMap takes the size of the board and the ranges of feed and kill rates to span.
+// It returns feed and kill rate fields that lay the whole (f, k) phase diagram out over the board,
+// as in the parameter maps of Pearson (1993): k grows from kMin in the left column to kMax in the
+// right one, and f from fMin in the bottom row to fMax in the top one.
+func PearsonMap(numRows, numCols int, fMin, fMax, kMin, kMax float64) (Field, Field) {
+
+	feed := FieldFunc(func(row, col int) float64 {
+		return fMin + (fMax-fMin)*fraction(numRows-1-row, numRows)
+	})
+	kill := FieldFunc(func(row, col int) float64 {
+		return kMin + (kMax-kMin)*fraction(col, numCols)
+	})
+	return feed, kill
+}
+
+// Input: a position along an axis and the length of the axis.
+// Return: how far along the axis the position is, from 0 at the first cell to 1 at the last.
+func fraction(i, n int) float64 {
+	if n <= 1 {
+		return 0
+	}
+	return float64(i) / float64(n-1)
+}
+
+// LoadField takes a .csv file of numbers or an image, the size of the board and the range of values
+// an image spans.
+// It returns the values as a FieldGrid. A CSV file must have one number for every cell of the board.
+// An image is stretched over the board, and the brightness of each pixel is mapped from black at
+// min to white at max.
+func LoadField(filename string, numRows, numCols int, min, max float64) (FieldGrid, error) {
+	if strings.HasSuffix(strings.ToLower(filename), ".csv") {
+		return loadFieldCSV(filename, numRows, numCols)
+	}
+	return loadFieldImage(filename, numRows, numCols, min, max)
+}
+
+// Input: the name of a CSV file and the size of the board.
+// Return: the numbers in the file, which must have one for every cell.
+func loadFieldCSV(filename string, numRows, numCols int) (FieldGrid, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) != numRows {
+		return nil, fmt.Errorf("%s has %d rows, but the board has %d", filename, len(records), numRows)
+	}
+
+	field := make(FieldGrid, numRows)
+	for r, record := range records {
+		if len(record) != numCols {
+			return nil, fmt.Errorf("row %d of %s has %d values, but the board has %d columns", r, filename, len(record), numCols)
+		}
+		field[r] = make([]float64, numCols)
+		for c, text := range record {
+			field[r][c], err = strconv.ParseFloat(strings.TrimSpace(text), 64)
+			if err != nil {
+				return nil, fmt.Errorf("row %d of %s: %q is not a number", r, filename, text)
+			}
+		}
+	}
+	return field, nil
+}
+
+// Input: the name of an image, the size of the board and the values black and white map to.
+// Return: the brightness of the image at every cell, mapped onto [min, max].
+func loadFieldImage(filename string, numRows, numCols int, min, max float64) (FieldGrid, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Empty() {
+		return nil, errors.New(filename + " is an empty image")
+	}
+
+	field := make(FieldGrid, numRows)
+	for r := range field {
+		field[r] = make([]float64, numCols)
+		for c := range field[r] {
+			// the pixel the cell falls on when the image is stretched over the board
+			x := bounds.Min.X + c*bounds.Dx()/numCols
+			y := bounds.Min.Y + r*bounds.Dy()/numRows
+			gray := color.GrayModel.Convert(img.At(x, y)).(color.Gray)
+			field[r][c] = min + (max-min)*float64(gray.Y)/255
+		}
+	}
+	return field, nil
+}
@@ -3,22 +3,30 @@
 
 package main
 
-// Input: an initial Board, a number of generations, and several parameters.
+import "math/rand"
+
+// Input: an initial Board, a number of generations, the ReactionModel of the two species, their diffusion
+// rates and kernels, and the Boundary of the board.
 // Return: a slice of boards of length numGens+1 to simulate the GrayScott model over numGens generations, using the initial board.
-func SimulateGrayScott(initialBoard Board, numGens int, feedRate, killRate, preyDiffusionRate, predatorDiffusionRate float64, kernel [3][3]float64) []Board {
+// It panics if a kernel is not valid.
+func SimulateGrayScott(initialBoard Board, numGens int, model ReactionModel, preyDiffusionRate, predatorDiffusionRate float64, kernels Kernels, boundary Boundary) []Board {
+
+	if err := kernels.Validate(); err != nil {
+		panic("Error: " + err.Error())
+	}
 
 	boards := make([]Board, numGens+1)
 	boards[0] = initialBoard
 
 	for i := 1; i <= numGens; i++ {
-		boards[i] = UpdateBoard(boards[i-1], feedRate, killRate, preyDiffusionRate, predatorDiffusionRate, kernel)
+		boards[i] = UpdateBoard(boards[i-1], model, preyDiffusionRate, predatorDiffusionRate, kernels, boundary)
 	}
 	return boards
 }
 
-// Input: a Board, a number of generations, and several parameters.
-// Return: the board from simulating the grayScott model for one generation according to the parameters passed.
-func UpdateBoard(currentBoard Board, feedRate, killRate, preyDiffusionRate, predatorDiffusionRate float64, kernel [3][3]float64) Board {
+// Input: a Board, the ReactionModel, several parameters, and the Boundary of the board.
+// Return: the board from simulating the reaction model for one generation according to the parameters passed.
+func UpdateBoard(currentBoard Board, model ReactionModel, preyDiffusionRate, predatorDiffusionRate float64, kernels Kernels, boundary Boundary) Board {
 
 	numRows := CountRows(currentBoard)
 	numCols := CountCols(currentBoard)
@@ -26,19 +34,84 @@ func UpdateBoard(currentBoard Board, feedRate, killRate, preyDiffusionRate, pred
 
 	for row := 0; row < numRows; row++ {
 		for col := 0; col < numCols; col++ {
-			newBoard[row][col] = UpdateCell(currentBoard, row, col, feedRate, killRate, preyDiffusionRate, predatorDiffusionRate, kernel)
+			newBoard[row][col] = UpdateCell(currentBoard, row, col, model, preyDiffusionRate, predatorDiffusionRate, kernels, boundary)
 		}
 	}
 	return newBoard
 }
 
-// Input: a Board with row/column values, and several parameters.
+// Input: an initial Board, a number of generations, the number of processors, several parameters, and the Boundary of the board.
+// Return: the same slice of boards as SimulateGrayScott, with every generation updated by UpdateBoardParallel.
+// It panics if a kernel is not valid.
+func SimulateGrayScottParallel(initialBoard Board, numGens, numProcs int, model ReactionModel, preyDiffusionRate, predatorDiffusionRate float64, kernels Kernels, boundary Boundary) []Board {
+
+	if err := kernels.Validate(); err != nil {
+		panic("Error: " + err.Error())
+	}
+
+	boards := make([]Board, numGens+1)
+	boards[0] = initialBoard
+
+	for i := 1; i <= numGens; i++ {
+		boards[i] = UpdateBoardParallel(boards[i-1], numProcs, model, preyDiffusionRate, predatorDiffusionRate, kernels, boundary)
+	}
+	return boards
+}
+
+// Input: a Board, the number of processors, several parameters, and the Boundary of the board.
+// Return: the board UpdateBoard would return, bit for bit. The rows are split into one band per processor,
+// and every processor updates its band reading only from the current board, which nothing writes to.
+func UpdateBoardParallel(currentBoard Board, numProcs int, model ReactionModel, preyDiffusionRate, predatorDiffusionRate float64, kernels Kernels, boundary Boundary) Board {
+
+	numRows := CountRows(currentBoard)
+	numCols := CountCols(currentBoard)
+	newBoard := InitializeBoard(numRows, numCols)
+
+	if numProcs > numRows {
+		numProcs = numRows
+	}
+	if numProcs < 1 {
+		numProcs = 1
+	}
+
+	finished := make(chan bool, numProcs)
+	chunkSize := numRows / numProcs
+	for i := 0; i < numProcs; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		// the last processor takes the rows left over
+		if i == numProcs-1 {
+			end = numRows
+		}
+		go updateRows(currentBoard, newBoard, start, end, model, preyDiffusionRate, predatorDiffusionRate, kernels, boundary, finished)
+	}
+	for i := 0; i < numProcs; i++ {
+		<-finished
+	}
+	return newBoard
+}
+
+// Input: the current and new Boards, the rows [start, end) to update, several parameters, the Boundary
+// of the board and a channel to indicate the process has finished.
+// Return: nothing, but the rows of newBoard are set to their next generation.
+func updateRows(currentBoard, newBoard Board, start, end int, model ReactionModel, preyDiffusionRate, predatorDiffusionRate float64, kernels Kernels, boundary Boundary, finished chan bool) {
+
+	for row := start; row < end; row++ {
+		for col := range newBoard[row] {
+			newBoard[row][col] = UpdateCell(currentBoard, row, col, model, preyDiffusionRate, predatorDiffusionRate, kernels, boundary)
+		}
+	}
+	finished <- true
+}
+
+// Input: a Board with row/column values, the ReactionModel, several parameters, and the Boundary of the board.
 // Return: the state of the cell at this row and column in the next generation of the update cell state at given row and col values.
-func UpdateCell(currentBoard Board, row, col int, feedRate, killRate, preyDiffusionRate, predatorDiffusionRate float64, kernel [3][3]float64) Cell {
+// The reactions in the cell are given by the model, so the same diffusion serves every model.
+func UpdateCell(currentBoard Board, row, col int, model ReactionModel, preyDiffusionRate, predatorDiffusionRate float64, kernels Kernels, boundary Boundary) Cell {
 
 	currentCell := currentBoard[row][col]
-	diffusionValues := ChangeDueToDiffusion(currentBoard, row, col, preyDiffusionRate, predatorDiffusionRate, kernel)
-	reactionValues := ChangeDueToReactions(currentCell, feedRate, killRate)
+	diffusionValues := ChangeDueToDiffusion(currentBoard, row, col, preyDiffusionRate, predatorDiffusionRate, kernels, boundary)
+	reactionValues := model.Change(currentCell, row, col)
 
 	return SumCells(currentCell, diffusionValues, reactionValues)
 }
@@ -56,8 +129,14 @@ func SumCells(cells ...Cell) Cell {
 	return sum
 }
 
+// Input: a Cell and a factor.
+// Return: both values of the cell multiplied by the factor.
+func ScaleCell(cell Cell, factor float64) Cell {
+	return Cell{cell[0] * factor, cell[1] * factor}
+}
+
 // Input: a Cell and several parameters to simulate reaction rates.
-// Return: the reaction rates from both feeding and killing.
+// Return: the reaction rates from both feeding and killing in the Gray-Scott model.
 func ChangeDueToReactions(currentCell Cell, feedRate, killRate float64) Cell {
 
 	var change Cell = [2]float64{0, 0}
@@ -68,19 +147,26 @@ func ChangeDueToReactions(currentCell Cell, feedRate, killRate float64) Cell {
 	return change
 }
 
-// Input: a Board, row/column values, several parameters, and a kernel to simulate diffusion.
+// Input: a Board, row/column values, several parameters, the kernels to simulate the diffusion of each
+// species, and the Boundary that supplies the concentrations a kernel reaches beyond the edges of the board.
 // Return: the diffusion rates for both prey and predator.
-func ChangeDueToDiffusion(currentBoard Board, row, col int, preyDiffusionRate, predatorDiffusionRate float64, kernel [3][3]float64) Cell {
+func ChangeDueToDiffusion(currentBoard Board, row, col int, preyDiffusionRate, predatorDiffusionRate float64, kernels Kernels, boundary Boundary) Cell {
 
 	var diffusion Cell = [2]float64{0, 0}
 
-	for kernelRows := -1; kernelRows <= 1; kernelRows++ {
-		for kernelCols := -1; kernelCols <= 1; kernelCols++ {
-			finalRows := row + kernelRows
-			finalCols := col + kernelCols
-			if InField(currentBoard, finalRows, finalCols) {
-				diffusion[0] += currentBoard[finalRows][finalCols][0] * kernel[kernelRows+1][kernelCols+1]
-				diffusion[1] += currentBoard[finalRows][finalCols][1] * kernel[kernelRows+1][kernelCols+1]
+	for species, kernel := range kernels {
+		radius := kernel.Radius()
+		for kernelRows := -radius; kernelRows <= radius; kernelRows++ {
+			for kernelCols := -radius; kernelCols <= radius; kernelCols++ {
+				finalRows := row + kernelRows
+				finalCols := col + kernelCols
+				var neighbour Cell
+				if InField(currentBoard, finalRows, finalCols) {
+					neighbour = currentBoard[finalRows][finalCols]
+				} else {
+					neighbour = CellBeyond(currentBoard, boundary, finalRows, finalCols)
+				}
+				diffusion[species] += neighbour[species] * kernel[kernelRows+radius][kernelCols+radius]
 			}
 		}
 	}
@@ -127,3 +213,36 @@ func InitializeBoard(numRows, numCols int) Board {
 	}
 	return currentBoard
 }
+
+// Input: a number of rows and columns, the fraction of each side covered by the central square of
+// predators, an amount of noise and a random number generator.
+// Return: a Board full of prey with predators in a central square. If noise is positive, every
+// cell also gets a random predator concentration of up to noise, which breaks the symmetry of the square.
+func InitialBoard(numRows, numCols int, frac, noise float64, rng *rand.Rand) Board {
+
+	initialBoard := InitializeBoard(numRows, numCols)
+
+	// how many predator rows and columns are there?
+	predRows := frac * float64(numRows)
+	predCols := frac * float64(numCols)
+
+	midRow := numRows / 2
+	midCol := numCols / 2
+
+	for r := midRow - int(predRows/2); r < midRow+int(predRows/2); r++ {
+		for c := midCol - int(predCols/2); c < midCol+int(predCols/2); c++ {
+			initialBoard[r][c][1] = 1.0
+		}
+	}
+
+	// make prey concentration 1 at every cell
+	for i := range initialBoard {
+		for j := range initialBoard[i] {
+			initialBoard[i][j][0] = 1.0
+			if noise > 0 {
+				initialBoard[i][j][1] += noise * rng.Float64()
+			}
+		}
+	}
+	return initialBoard
+}
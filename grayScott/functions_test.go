@@ -0,0 +1,926 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/cmplx"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var isotropicKernel = SameKernel(IsotropicLaplacian())
+
+func TestMassConservedUnderDiffusion(t *testing.T) {
+	for _, boundary := range []Boundary{Periodic{}, Neumann{}} {
+		for _, kernelName := range []string{"isotropic", "five-point", "gaussian"} {
+			kernel, err := KernelFromName(kernelName, 2, 1.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// With a single species and no feed or kill there are no reactions, only diffusion
+			for species := 0; species < 2; species++ {
+				board := randomBoard(13, 17, 1)
+				for r := range board {
+					for c := range board[r] {
+						board[r][c][1-species] = 0
+					}
+				}
+				initialMass := TotalMass(board)
+
+				boards := SimulateGrayScott(board, 50, GrayScott{0, 0}, 0.2, 0.1, SameKernel(kernel), boundary)
+				finalMass := TotalMass(boards[len(boards)-1])
+				if math.Abs(finalMass[species]-initialMass[species]) > 1e-9*initialMass[species] {
+					t.Errorf("%T with the %s kernel: mass of species %d went from %v to %v", boundary, kernelName, species, initialMass[species], finalMass[species])
+				}
+			}
+		}
+	}
+}
+
+func TestDiffusionSumsToZero(t *testing.T) {
+	board := randomBoard(9, 6, 2)
+	for _, boundary := range []Boundary{Periodic{}, Neumann{}} {
+		var total Cell
+		for r := range board {
+			for c := range board[r] {
+				total = SumCells(total, ChangeDueToDiffusion(board, r, c, 0.2, 0.1, isotropicKernel, boundary))
+			}
+		}
+		if math.Abs(total[0]) > 1e-12 || math.Abs(total[1]) > 1e-12 {
+			t.Errorf("%T: diffusion over the board adds up to %v, expected 0", boundary, total)
+		}
+	}
+}
+
+func TestDirichlet(t *testing.T) {
+
+	// A board at the boundary value never changes through diffusion
+	value := Cell{0.7, 0.3}
+	board := InitializeBoard(5, 8)
+	for r := range board {
+		for c := range board[r] {
+			board[r][c] = value
+		}
+	}
+	for r := range board {
+		for c := range board[r] {
+			change := ChangeDueToDiffusion(board, r, c, 0.2, 0.1, isotropicKernel, Dirichlet{Value: value})
+			if math.Abs(change[0]) > 1e-12 || math.Abs(change[1]) > 1e-12 {
+				t.Fatalf("cell %d,%d of a board at the boundary value changes by %v", r, c, change)
+			}
+		}
+	}
+
+	// A zero boundary only sees the cells on the board
+	board = randomBoard(4, 4, 3)
+	change := ChangeDueToDiffusion(board, 0, 0, 1, 1, isotropicKernel, Dirichlet{})
+	for species := 0; species < 2; species++ {
+		expected := -board[0][0][species] + .2*board[0][1][species] + .2*board[1][0][species] + .05*board[1][1][species]
+		if math.Abs(change[species]-expected) > 1e-12 {
+			t.Errorf("corner with a zero boundary changes species %d by %v, expected %v", species, change[species], expected)
+		}
+	}
+}
+
+func TestBoundaryIndices(t *testing.T) {
+	board := randomBoard(3, 4, 4)
+
+	tests := []struct {
+		boundary Boundary
+		i, j     int
+		row, col int
+	}{
+		{Periodic{}, -1, 0, 2, 0},
+		{Periodic{}, 3, 4, 0, 0},
+		{Periodic{}, 1, -5, 1, 3},
+		{Neumann{}, -1, 0, 0, 0},
+		{Neumann{}, -2, 4, 1, 3},
+		{Neumann{}, 3, 5, 2, 2},
+		{Neumann{}, -4, 0, 2, 0},
+	}
+	for _, test := range tests {
+		if got := CellBeyond(board, test.boundary, test.i, test.j); got != board[test.row][test.col] {
+			t.Errorf("%T at %d,%d gave %v, expected cell %d,%d %v", test.boundary, test.i, test.j, got, test.row, test.col, board[test.row][test.col])
+		}
+	}
+}
+
+func TestParallelMatchesSerial(t *testing.T) {
+	board := randomBoard(23, 19, 5)
+	boundaries := []Boundary{Periodic{}, Neumann{}, Dirichlet{Value: Cell{1, 0}}}
+	for _, boundary := range boundaries {
+		serial := SimulateGrayScott(board, 20, GrayScott{0.0367, 0.0649}, 0.2, 0.1, isotropicKernel, boundary)
+		for _, numProcs := range []int{1, 2, 3, 7, 50} {
+			parallel := SimulateGrayScottParallel(board, 20, numProcs, GrayScott{0.0367, 0.0649}, 0.2, 0.1, isotropicKernel, boundary)
+			for gen := range serial {
+				if !boardsEqual(serial[gen], parallel[gen]) {
+					t.Fatalf("%T with %d processors differs from serial at generation %d", boundary, numProcs, gen)
+				}
+			}
+		}
+	}
+}
+
+func TestFlatMatchesBoards(t *testing.T) {
+	board := randomBoard(17, 12, 6)
+	boundaries := []Boundary{Periodic{}, Neumann{}, Dirichlet{Value: Cell{1, 0}}}
+	for _, boundary := range boundaries {
+		boards := SimulateGrayScott(board, 25, GrayScott{0.0367, 0.0649}, 0.2, 0.1, isotropicKernel, boundary)
+		for _, numProcs := range []int{1, 4} {
+			params := Parameters{
+				Model:                 GrayScott{0.0367, 0.0649},
+				PreyDiffusionRate:     0.2,
+				PredatorDiffusionRate: 0.1,
+				Kernels:               isotropicKernel,
+				Boundary:              boundary,
+				NumProcs:              numProcs,
+			}
+
+			saved := make([]int, 0)
+			final, err := SimulateGrayScottFlat(board, 25, 5, params, func(gen int, current, previous Grid) error {
+				saved = append(saved, gen)
+				if !boardsEqual(current.Board(), boards[gen]) {
+					t.Errorf("%T with %d processors differs from SimulateGrayScott at generation %d", boundary, numProcs, gen)
+				}
+				if gen == 0 && previous.Data != nil || gen > 0 && !boardsEqual(previous.Board(), boards[gen-1]) {
+					t.Errorf("%T with %d processors passes the wrong previous generation with generation %d", boundary, numProcs, gen)
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(saved) != 6 || saved[5] != 25 {
+				t.Errorf("saved generations %v, expected 0, 5, ..., 25", saved)
+			}
+			if !boardsEqual(final, boards[25]) {
+				t.Errorf("%T with %d processors ends on a different board", boundary, numProcs)
+			}
+		}
+	}
+
+	// kernels of different sizes for each species reach over the edges of a small board
+	gaussian, _ := GaussianKernel(2, 1)
+	biased := Kernel{{0, 0, 0}, {.3, -1, .7}, {0, 0, 0}}
+	for _, boundary := range boundaries {
+		kernels := Kernels{gaussian, biased}
+		boards := SimulateGrayScott(board, 10, GrayScott{0.03, 0.062}, 0.2, 0.1, kernels, boundary)
+		params := Parameters{Model: GrayScott{0.03, 0.062}, PreyDiffusionRate: 0.2, PredatorDiffusionRate: 0.1, Kernels: kernels, Boundary: boundary}
+		final, err := SimulateGrayScottFlat(board, 10, 10, params, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !boardsEqual(final, boards[10]) {
+			t.Errorf("%T with separate kernels differs from SimulateGrayScott", boundary)
+		}
+	}
+	if _, err := SimulateGrayScottFlat(board, 1, 1, Parameters{Kernels: Kernels{biased, {{1, 0}}}}, nil); err == nil {
+		t.Errorf("SimulateGrayScottFlat accepted an even kernel")
+	}
+	if _, err := SimulateGrayScottFlat(board, 1, 0, Parameters{Model: GrayScott{}, Kernels: isotropicKernel, Boundary: Neumann{}}, nil); err == nil {
+		t.Errorf("SimulateGrayScottFlat accepted no generations between saves")
+	}
+
+	// an error from save stops the run
+	stop := errors.New("stop")
+	if _, err := SimulateGrayScottFlat(board, 10, 1, Parameters{Model: GrayScott{}, Kernels: isotropicKernel, Boundary: Neumann{}}, func(gen int, current, previous Grid) error {
+		if gen == 3 {
+			return stop
+		}
+		return nil
+	}); err != stop {
+		t.Errorf("save error gave %v", err)
+	}
+	if !boardsEqual(GridFromBoard(board).Board(), board) {
+		t.Errorf("a board does not survive a round trip through a Grid")
+	}
+}
+
+func TestKernels(t *testing.T) {
+	gaussian, err := GaussianKernel(3, 1.2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(gaussian) != 7 || gaussian.Radius() != 3 || gaussian[3][3] != -1 {
+		t.Fatalf("gaussian kernel of radius 3 is %v", gaussian)
+	}
+	for i := range gaussian {
+		for j := range gaussian {
+			if gaussian[i][j] != gaussian[j][i] || gaussian[i][j] != gaussian[6-i][j] {
+				t.Fatalf("gaussian kernel is not symmetric at %d,%d", i, j)
+			}
+		}
+	}
+	if !(gaussian[3][2] > gaussian[2][2] && gaussian[2][2] > gaussian[0][0] && gaussian[0][0] > 0) {
+		t.Errorf("gaussian weights don't fall with distance: %v", gaussian)
+	}
+
+	for _, k := range []Kernel{IsotropicLaplacian(), FivePointLaplacian(), gaussian} {
+		if err := k.Validate(); err != nil {
+			t.Errorf("generated kernel %v is not valid: %v", k, err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"isotropic", true},
+		{"five-point", true},
+		{"gaussian", true},
+		{"0,1,0;1,-2,0;0,0,0", true},
+		{"0,1,0;1,-1,0;0,0,0", false},
+		{"1,-1;0,0", false},
+		{"0,1,0;1,-2", false},
+		{"0,x,0;1,-1,0;0,0,0", false},
+		{"laplacian", false},
+	}
+	for _, test := range tests {
+		_, err := KernelFromName(test.name, 2, 1)
+		if (err == nil) != test.valid {
+			t.Errorf("KernelFromName(%q) gave error %v", test.name, err)
+		}
+	}
+	if _, err := GaussianKernel(0, 1); err == nil {
+		t.Errorf("a gaussian kernel of radius 0 was accepted")
+	}
+
+	// the board functions refuse kernels that would create or destroy mass
+	defer func() {
+		if recover() == nil {
+			t.Errorf("SimulateGrayScott accepted a kernel that doesn't sum to zero")
+		}
+	}()
+	SimulateGrayScott(randomBoard(3, 3, 1), 1, GrayScott{}, 0.2, 0.1, Kernels{IsotropicLaplacian(), {{1}}}, Periodic{})
+}
+
+func TestReactionModels(t *testing.T) {
+	cell := Cell{0.5, 2}
+	tests := []struct {
+		name     string
+		values   string
+		expected Cell
+	}{
+		{"gray-scott", "f=0.04,k=0.1", Cell{0.04*0.5 - 0.5*4, 0.5*4 - 0.1*2}},
+		{"fitzhugh-nagumo", "", Cell{0.1 * (0.5 - 0.125 - 2), 0.1 * 0.05 * (0.5 - 2*2 + 0.005)}},
+		{"brusselator", "a=1,b=3,gamma=2", Cell{2 * (1 - 4*0.5 + 0.25*2), 2 * (3*0.5 - 0.25*2)}},
+		{"schnakenberg", "gamma=2", Cell{2 * (0.1 - 0.5 + 0.25*2), 2 * (0.9 - 0.25*2)}},
+		{"lotka-volterra", "alpha=1, beta=0.5", Cell{0.5 - 0.5*0.5*2, 0.2*0.5*2 - 0.05*2}},
+	}
+	for _, test := range tests {
+		values, err := ParseReactionParameters(test.values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		model, err := ModelFromName(test.name, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		change := model.Change(cell, 0, 0)
+		if math.Abs(change[0]-test.expected[0]) > 1e-12 || math.Abs(change[1]-test.expected[1]) > 1e-12 {
+			t.Errorf("%s changes %v by %v, expected %v", test.name, cell, change, test.expected)
+		}
+	}
+
+	// the defaults of every model must run on a board at the diffusion rates and time step of the Gray-Scott presets
+	for _, name := range ModelNames {
+		model, err := ModelFromName(name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		params := Parameters{Model: model, PreyDiffusionRate: 0.2, PredatorDiffusionRate: 0.1, Kernels: isotropicKernel, Boundary: Periodic{}, Dt: 1}
+		if _, err := SimulateGrayScottFlat(randomBoard(16, 16, 8), 500, 500, params, nil); err != nil {
+			t.Errorf("%s with its default parameters: %v", name, err)
+		}
+	}
+
+	if _, err := ModelFromName("brusselator", map[string]float64{"f": 1}); err == nil {
+		t.Errorf("brusselator accepted a parameter it doesn't have")
+	}
+	if _, err := ModelFromName("turing", nil); err == nil {
+		t.Errorf("an unknown model was accepted")
+	}
+	for _, text := range []string{"a", "a=1=2", "a=x"} {
+		if _, err := ParseReactionParameters(text); err == nil {
+			t.Errorf("reaction parameters %q were accepted", text)
+		}
+	}
+
+	// the board functions only see the model, so any model runs on them
+	board := randomBoard(8, 8, 7)
+	boards := SimulateGrayScott(board, 5, Schnakenberg{A: 0.1, B: 0.9, Gamma: 0.05}, 0.2, 0.1, isotropicKernel, Neumann{})
+	expected := UpdateBoard(boards[3], Schnakenberg{A: 0.1, B: 0.9, Gamma: 0.05}, 0.2, 0.1, isotropicKernel, Neumann{})
+	if !boardsEqual(boards[4], expected) {
+		t.Errorf("schnakenberg generations don't follow from each other")
+	}
+}
+
+func TestFields(t *testing.T) {
+	feed, kill := PearsonMap(5, 9, 0.01, 0.09, 0.04, 0.08)
+	if feed.At(4, 0) != 0.01 || feed.At(0, 8) != 0.09 || math.Abs(feed.At(2, 3)-0.05) > 1e-12 {
+		t.Errorf("pearson feed rates run %v to %v, middle %v", feed.At(4, 0), feed.At(0, 8), feed.At(2, 3))
+	}
+	if kill.At(0, 0) != 0.04 || kill.At(4, 8) != 0.08 || math.Abs(kill.At(1, 4)-0.06) > 1e-12 {
+		t.Errorf("pearson kill rates run %v to %v, middle %v", kill.At(0, 0), kill.At(4, 8), kill.At(1, 4))
+	}
+
+	// each cell reacts with its own rates
+	board := randomBoard(5, 9, 8)
+	model := GrayScottField{Feed: feed, Kill: kill}
+	next := UpdateBoard(board, model, 0, 0, isotropicKernel, Periodic{})
+	for r := range board {
+		for c := range board[r] {
+			expected := SumCells(board[r][c], ChangeDueToReactions(board[r][c], feed.At(r, c), kill.At(r, c)))
+			if next[r][c] != expected {
+				t.Fatalf("cell %d,%d is %v, expected %v", r, c, next[r][c], expected)
+			}
+		}
+	}
+
+	// constant fields are the plain model
+	constant := UpdateBoard(board, GrayScottField{Feed: Constant(0.03), Kill: Constant(0.06)}, 0.2, 0.1, isotropicKernel, Periodic{})
+	if !boardsEqual(constant, UpdateBoard(board, GrayScott{0.03, 0.06}, 0.2, 0.1, isotropicKernel, Periodic{})) {
+		t.Errorf("constant fields differ from the gray-scott model")
+	}
+
+	dir := t.TempDir()
+	csvFile := filepath.Join(dir, "feed.csv")
+	os.WriteFile(csvFile, []byte("0.01,0.02,0.03\n0.04,0.05,0.06\n"), 0644)
+	field, err := LoadField(csvFile, 2, 3, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if field.At(1, 2) != 0.06 || field.At(0, 1) != 0.02 {
+		t.Errorf("CSV field is %v", field)
+	}
+	if _, err := LoadField(csvFile, 3, 3, 0, 1); err == nil {
+		t.Errorf("a CSV field of the wrong size was accepted")
+	}
+
+	// a 2x2 image with a black and a white column, stretched over 4 columns
+	img := image.NewGray(image.Rect(0, 0, 2, 2))
+	img.SetGray(1, 0, color.Gray{Y: 255})
+	img.SetGray(1, 1, color.Gray{Y: 255})
+	imageFile := filepath.Join(dir, "kill.png")
+	file, _ := os.Create(imageFile)
+	png.Encode(file, img)
+	file.Close()
+	field, err = LoadField(imageFile, 3, 4, 0.05, 0.07)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range field {
+		if field[r][0] != 0.05 || field[r][1] != 0.05 || field[r][2] != 0.07 || field[r][3] != 0.07 {
+			t.Errorf("row %d of the image field is %v", r, field[r])
+		}
+	}
+}
+
+func TestFFT(t *testing.T) {
+	rng := rand.New(rand.NewSource(8))
+	for _, n := range []int{1, 2, 5, 8, 12} {
+		x := make([]complex128, n)
+		for i := range x {
+			x[i] = complex(rng.Float64(), rng.Float64())
+		}
+
+		// the transform must agree with the sum that defines it
+		got := append([]complex128(nil), x...)
+		newFFTPlan(n).transform(got, false)
+		for k := range x {
+			var want complex128
+			for j := range x {
+				want += x[j] * cmplx.Exp(complex(0, -2*math.Pi*float64(j*k)/float64(n)))
+			}
+			if cmplx.Abs(got[k]-want) > 1e-9 {
+				t.Errorf("length %d: transform %d is %v, expected %v", n, k, got[k], want)
+			}
+		}
+	}
+
+	// the inverse of a two-dimensional transform gives back what was transformed
+	data := make([]complex128, 6*8)
+	for i := range data {
+		data[i] = complex(rng.Float64(), 0)
+	}
+	got := append([]complex128(nil), data...)
+	transform := newFFT2(6, 8)
+	transform.transform(got, false)
+	transform.transform(got, true)
+	for i := range data {
+		if cmplx.Abs(got[i]-data[i]) > 1e-12 {
+			t.Fatalf("value %d is %v after a round trip, expected %v", i, got[i], data[i])
+		}
+	}
+}
+
+func TestIntegrators(t *testing.T) {
+	board := randomBoard(16, 12, 9)
+	params := Parameters{Model: GrayScott{0.0367, 0.0649}, PreyDiffusionRate: 0.2, PredatorDiffusionRate: 0.1, Kernels: isotropicKernel, Boundary: Periodic{}}
+	run := func(integrator string, dt float64, numGens int) Board {
+		params.Integrator, params.Dt = integrator, dt
+		final, err := SimulateGrayScottFlat(board, numGens, numGens, params, nil)
+		if err != nil {
+			t.Fatalf("%s with dt %g: %v", integrator, dt, err)
+		}
+		return final
+	}
+
+	// every integrator follows the same solution up to time 4, each as closely as its order allows
+	reference := run("rk4", 0.05, 80)
+	for _, test := range []struct {
+		integrator string
+		dt         float64
+		tolerance  float64
+	}{
+		{"euler", 0.01, 1e-3},
+		{"rk4", 0.5, 1e-3},
+		{"semi-implicit", 0.05, 1e-2},
+	} {
+		final := run(test.integrator, test.dt, int(math.Round(4/test.dt)))
+		if difference := MaxChange(final, reference); difference > test.tolerance {
+			t.Errorf("%s with dt %g is %g away from the reference, more than %g", test.integrator, test.dt, difference, test.tolerance)
+		}
+	}
+
+	// a step of 1 is the step the program has always taken
+	boards := SimulateGrayScott(board, 5, params.Model, 0.2, 0.1, isotropicKernel, Periodic{})
+	if !boardsEqual(run("euler", 1, 5), boards[5]) || !boardsEqual(run("", 0, 5), boards[5]) {
+		t.Errorf("euler with dt 1 differs from SimulateGrayScott")
+	}
+
+	// the semi-implicit step stays stable, bounded and conserves each species far beyond the explicit limit
+	params = Parameters{Model: LotkaVolterra{}, PreyDiffusionRate: 1, PredatorDiffusionRate: 0.5, Kernels: isotropicKernel, Boundary: Periodic{}, Integrator: "semi-implicit", Dt: 50}
+	final, err := SimulateGrayScottFlat(board, 20, 20, params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	before, after := TotalMass(board), TotalMass(final)
+	for species := range before {
+		if math.Abs(after[species]-before[species]) > 1e-9 {
+			t.Errorf("semi-implicit diffusion changed the total of species %d from %v to %v", species, before[species], after[species])
+		}
+	}
+	for r := range final {
+		for c := range final[r] {
+			if final[r][c][0] < 0 || final[r][c][0] > 1 || final[r][c][1] < 0 || final[r][c][1] > 1 {
+				t.Fatalf("semi-implicit diffusion left cell (%d, %d) at %v", r, c, final[r][c])
+			}
+		}
+	}
+	params.Boundary = Neumann{}
+	if _, err := SimulateGrayScottFlat(board, 1, 1, params, nil); err == nil {
+		t.Errorf("the semi-implicit integrator accepted a Neumann boundary")
+	}
+	params.Boundary, params.Integrator = Periodic{}, "leapfrog"
+	if _, err := SimulateGrayScottFlat(board, 1, 1, params, nil); err == nil {
+		t.Errorf("SimulateGrayScottFlat accepted an unknown integrator")
+	}
+}
+
+func TestStability(t *testing.T) {
+	// the isotropic kernel is -1.6 at its most negative, so euler is stable up to dt*D = 1.25
+	params := Parameters{Model: GrayScott{}, PreyDiffusionRate: 0.2, PredatorDiffusionRate: 0.1, Kernels: isotropicKernel, Boundary: Periodic{}}
+	for _, test := range []struct {
+		integrator string
+		dt         float64
+		stable     bool
+	}{
+		{"euler", 1, true},
+		{"euler", 6, true},
+		{"euler", 6.5, false},
+		{"rk4", 8, true},
+		{"rk4", 9, false},
+		{"semi-implicit", 1000, true},
+	} {
+		params.Integrator, params.Dt = test.integrator, test.dt
+		err := CheckStability(params)
+		if (err == nil) != test.stable {
+			t.Errorf("%s with dt %g: stability check gave %v", test.integrator, test.dt, err)
+		}
+	}
+
+	params.Integrator, params.Dt = "euler", 10
+	err := CheckStability(params)
+	if err == nil || !strings.Contains(err.Error(), "prey") || !strings.Contains(err.Error(), "6.25") {
+		t.Errorf("unstable euler step gave %v, expected the prey limit of 6.25", err)
+	}
+	if _, err := SimulateGrayScottFlat(randomBoard(5, 5, 1), 1, 1, params, nil); err == nil {
+		t.Errorf("SimulateGrayScottFlat ran an unstable step")
+	}
+}
+
+func TestNotFinite(t *testing.T) {
+	board := randomBoard(6, 7, 3)
+	if err := CheckFinite(board); err != nil {
+		t.Fatal(err)
+	}
+	board[2][4][1] = math.NaN()
+	if err := CheckFinite(board); err == nil || !strings.Contains(err.Error(), "predator") || !strings.Contains(err.Error(), "row 2, column 4") {
+		t.Errorf("NaN predator gave %v", err)
+	}
+	if _, err := SimulateGrayScottFlat(board, 1, 1, Parameters{Model: GrayScott{}, Kernels: isotropicKernel, Boundary: Periodic{}}, nil); err == nil {
+		t.Errorf("SimulateGrayScottFlat started from a NaN")
+	}
+
+	// prey that grow elevenfold every generation overflow long before 1000 generations
+	params := Parameters{Model: LotkaVolterra{Alpha: 10}, Kernels: isotropicKernel, Boundary: Periodic{}}
+	saved := 0
+	_, err := SimulateGrayScottFlat(randomBoard(6, 7, 3), 1000, 1, params, func(gen int, current, previous Grid) error {
+		saved++
+		return nil
+	})
+	if err == nil || !strings.Contains(err.Error(), "generation") {
+		t.Errorf("a diverging run gave %v", err)
+	}
+	if saved >= 1000 {
+		t.Errorf("a diverging run saved %d generations", saved)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	board := randomBoard(7, 5, 11)
+	board[3][2] = Cell{1e-300, -2.5}
+	metadata := map[string]string{"generation": "20000", "preset": "spots", "kernel": "0,1,0;1,-4,1;0,1,0"}
+
+	for _, name := range []string{"board.npy", "board.csv", "board.bin"} {
+		filename := filepath.Join(dir, name)
+		if err := SaveBoard(filename, board, metadata); err != nil {
+			t.Fatal(err)
+		}
+		loaded, loadedMetadata, err := LoadBoard(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !boardsEqual(loaded, board) {
+			t.Errorf("%s does not give back the board it saved", name)
+		}
+		if len(loadedMetadata) != len(metadata) {
+			t.Errorf("%s gives back metadata %v, expected %v", name, loadedMetadata, metadata)
+		}
+		for key, value := range metadata {
+			if loadedMetadata[key] != value {
+				t.Errorf("%s gives back %s=%q, expected %q", name, key, loadedMetadata[key], value)
+			}
+		}
+	}
+
+	// a plain .npy file, as numpy.save writes it, is a board without metadata
+	data, _ := os.ReadFile(filepath.Join(dir, "board.npy"))
+	plain := filepath.Join(dir, "plain.npy")
+	os.WriteFile(plain, data[:128+7*5*16], 0644)
+	loaded, loadedMetadata, err := LoadBoard(plain)
+	if err != nil || !boardsEqual(loaded, board) || len(loadedMetadata) != 0 {
+		t.Errorf("plain .npy file gave %v, metadata %v", err, loadedMetadata)
+	}
+
+	for name, contents := range map[string]string{
+		"short.npy":     string(data[:len(data)/2]),
+		"trailing.npy":  string(data) + "x",
+		"text.npy":      "row,col,prey,predator\n",
+		"header.csv":    "# generation=1\nr,c,u,v\n0,0,1,0\n",
+		"missing.csv":   "row,col,prey,predator\n0,0,1,0\n1,1,1,0\n",
+		"twice.csv":     "row,col,prey,predator\n0,0,1,0\n0,1,1,0\n0,1,1,0\n0,0,1,0\n",
+		"fraction.csv":  "row,col,prey,predator\n0,0.5,1,0\n",
+		"notfinite.csv": "row,col,prey,predator\n0,0,NaN,0\n",
+	} {
+		filename := filepath.Join(dir, name)
+		os.WriteFile(filename, []byte(contents), 0644)
+		if _, _, err := LoadBoard(filename); err == nil {
+			t.Errorf("%s was loaded", name)
+		}
+	}
+
+	// a run resumed from a saved board ends exactly where it would have without stopping
+	board = randomBoard(9, 8, 4)
+	whole := SimulateGrayScott(board, 20, GrayScott{0.03, 0.062}, 0.2, 0.1, isotropicKernel, Neumann{})
+	half := SimulateGrayScott(board, 10, GrayScott{0.03, 0.062}, 0.2, 0.1, isotropicKernel, Neumann{})
+	filename := filepath.Join(dir, "half.csv")
+	if err := SaveBoard(filename, half[10], nil); err != nil {
+		t.Fatal(err)
+	}
+	saved, _, err := LoadBoard(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resumed := SimulateGrayScott(saved, 10, GrayScott{0.03, 0.062}, 0.2, 0.1, isotropicKernel, Neumann{})
+	if !boardsEqual(resumed[10], whole[20]) {
+		t.Errorf("a resumed run differs from an unbroken one")
+	}
+}
+
+func TestBoardFromImage(t *testing.T) {
+	// a white 4x4 image with a black square in its top left corner
+	img := image.NewGray(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			img.SetGray(x, y, color.Gray{Y: 255})
+		}
+	}
+	img.SetGray(0, 0, color.Gray{Y: 0})
+	img.SetGray(1, 0, color.Gray{Y: 0})
+	img.SetGray(0, 1, color.Gray{Y: 0})
+	img.SetGray(1, 1, color.Gray{Y: 0})
+	filename := filepath.Join(t.TempDir(), "seed.png")
+	file, _ := os.Create(filename)
+	png.Encode(file, img)
+	file.Close()
+
+	board, err := BoardFromImage(filename, 8, 8, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range board {
+		for c := range board[r] {
+			expected := Cell{1, 0}
+			if r < 4 && c < 4 {
+				expected = Cell{1, 1}
+			}
+			if board[r][c] != expected {
+				t.Fatalf("cell %d,%d is %v, expected %v", r, c, board[r][c], expected)
+			}
+		}
+	}
+
+	board, err = BoardFromImage(filename, 8, 8, 0.1, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if board[7][7][1] <= 0 || board[7][7][1] > 0.1 {
+		t.Errorf("noise gave a white cell %v predators", board[7][7][1])
+	}
+	if _, err := BoardFromImage(filepath.Join(t.TempDir(), "missing.png"), 8, 8, 0, nil); err == nil {
+		t.Errorf("a missing image was loaded")
+	}
+}
+
+func TestDrawBoard(t *testing.T) {
+	// an empty cell has no predator fraction, and other models can take it outside [0, 1]
+	board := Board{{{0, 0}, {1, 0}}, {{-1, 2}, {1, 1}}}
+	img := DrawBoard(board, 2)
+	if img.Bounds().Dx() != 4 || img.Bounds().Dy() != 4 {
+		t.Errorf("board drawn as %v, expected 4x4", img.Bounds())
+	}
+	// rows run along x
+	if img.At(0, 0) != img.At(0, 2) {
+		t.Errorf("an empty cell is drawn as %v, expected the colour of a cell without predators %v", img.At(0, 0), img.At(0, 2))
+	}
+}
+
+func TestSpots(t *testing.T) {
+	board := InitialBoard(6, 8, 0, 0, nil)
+	for _, cell := range [][2]int{{1, 1}, {1, 2}, {2, 2}, {4, 4}, {0, 7}, {5, 7}, {3, 0}, {3, 7}} {
+		board[cell[0]][cell[1]][1] = 0.5
+	}
+	// a cell that only touches a spot at its corner is a spot of its own
+	board[5][5][1] = 0.5
+
+	// on a periodic board the cells on opposite edges join up into two spots: {0,7},{5,7} and {3,0},{3,7}
+	for _, test := range []struct {
+		boundary Boundary
+		sizes    int
+		cells    int
+	}{
+		{Dirichlet{}, 7, 9},
+		{Neumann{}, 7, 9},
+		{Periodic{}, 5, 9},
+	} {
+		sizes := Spots(board, 0.3, test.boundary)
+		total := 0
+		for _, size := range sizes {
+			total += size
+		}
+		if len(sizes) != test.sizes || total != test.cells {
+			t.Errorf("%T board has spots %v, expected %d spots of %d cells", test.boundary, sizes, test.sizes, test.cells)
+		}
+	}
+	if sizes := Spots(board, 0.5, Periodic{}); len(sizes) != 0 {
+		t.Errorf("cells at the threshold made spots %v", sizes)
+	}
+}
+
+func TestPowerSpectrum(t *testing.T) {
+	// stripes with a wavelength of 8 cells across the columns, and of 6 cells down the rows
+	for _, test := range []struct {
+		numRows, numCols int
+		wavelength       float64
+		down             bool
+	}{
+		{32, 32, 8, false},
+		{24, 32, 6, true},
+		{20, 15, 5, false},
+	} {
+		board := InitializeBoard(test.numRows, test.numCols)
+		for r := range board {
+			for c := range board[r] {
+				position := c
+				if test.down {
+					position = r
+				}
+				board[r][c] = Cell{1, 0.5 + 0.5*math.Cos(2*math.Pi*float64(position)/test.wavelength)}
+			}
+		}
+		// the spectrum is in whole cycles over the longer side, so a wavelength is only found to within a bin
+		side := math.Max(float64(test.numRows), float64(test.numCols))
+		if wavelength := DominantWavelength(board); math.Abs(wavelength-test.wavelength) > test.wavelength*test.wavelength/(2*side) {
+			t.Errorf("%dx%d stripes of wavelength %g have a dominant wavelength of %g", test.numRows, test.numCols, test.wavelength, wavelength)
+		}
+	}
+
+	if wavelength := DominantWavelength(InitialBoard(10, 10, 0, 0, nil)); wavelength != 0 {
+		t.Errorf("a uniform board has a dominant wavelength of %g", wavelength)
+	}
+	spectrum := RadialPowerSpectrum(randomBoard(16, 16, 2))
+	if len(spectrum) != 9 || spectrum[0] > 1e-20 {
+		t.Errorf("spectrum of a 16x16 board is %v", spectrum)
+	}
+}
+
+func TestMetricsWriter(t *testing.T) {
+	var output strings.Builder
+	m, err := NewMetricsWriter(&output, 0.3, 1e-6, Periodic{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a board that stops changing is steady, but one that comes back to itself every snapshot is not
+	board := InitialBoard(20, 20, 0.2, 0, nil)
+	next := UpdateBoard(board, GrayScott{0.03, 0.062}, 0.2, 0.1, isotropicKernel, Periodic{})
+	for i, snapshot := range []struct {
+		gen      int
+		board    Board
+		previous Board
+		steady   bool
+	}{
+		{0, board, nil, false},
+		{10, next, board, false},
+		{20, next, board, false},
+		{30, next, next, true},
+	} {
+		metrics, err := m.Record(snapshot.gen, snapshot.board, snapshot.previous)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if metrics.Steady != snapshot.steady || metrics.Generation != snapshot.gen {
+			t.Errorf("snapshot %d gave %+v", i, metrics)
+		}
+		if i == 0 && !math.IsNaN(metrics.Change) {
+			t.Errorf("the first snapshot has a change of %g", metrics.Change)
+		}
+		if i == 2 && metrics.Change != MaxChange(board, next) {
+			t.Errorf("change is %g, expected %g", metrics.Change, MaxChange(board, next))
+		}
+		if metrics.Mass != TotalMass(snapshot.board) || metrics.Spots != 1 || metrics.MeanSpotSize != 16 {
+			t.Errorf("snapshot %d has mass %v and %d spots of mean size %g", i, metrics.Mass, metrics.Spots, metrics.MeanSpotSize)
+		}
+	}
+	if err := m.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+	if len(lines) != 5 || lines[0] != "generation,prey_mass,predator_mass,spots,mean_spot_size,dominant_wavelength,change,steady" {
+		t.Fatalf("metrics CSV is\n%s", output.String())
+	}
+	if !strings.HasPrefix(lines[1], "0,400,16,1,16,") || !strings.HasSuffix(lines[1], ",,false") || !strings.HasSuffix(lines[4], ",0,true") {
+		t.Errorf("metrics CSV is\n%s", output.String())
+	}
+}
+
+func TestPresets(t *testing.T) {
+	names := PresetNames()
+	if len(names) != len(Presets) || !sort.StringsAreSorted(names) {
+		t.Errorf("preset names are %v", names)
+	}
+	for _, name := range names {
+		if _, ok := Presets[name]; !ok {
+			t.Errorf("%q is not a preset", name)
+		}
+	}
+
+	spots := Presets["spots"]
+	tests := []struct {
+		given map[string]float64
+		want  Preset
+	}{
+		{nil, spots},
+		{map[string]float64{"f": 0.05}, Preset{0.05, 0.062, 0.2, 0.1, spots.Description}},
+		{map[string]float64{"k": 0.07, "dv": 0.3}, Preset{0.030, 0.07, 0.2, 0.3, spots.Description}},
+		{map[string]float64{"f": 0, "k": 0, "du": 0, "dv": 0}, Preset{Description: spots.Description}},
+		{map[string]float64{"gens": 10}, spots},
+	}
+	for i, test := range tests {
+		if got := spots.Override(test.given); got != test.want {
+			t.Errorf("override %d gave %+v, expected %+v", i, got, test.want)
+		}
+	}
+	if Presets["spots"] != spots {
+		t.Errorf("overriding a preset changed Presets")
+	}
+}
+
+func TestNames(t *testing.T) {
+	outputs := []struct {
+		label    string
+		reaction map[string]float64
+		want     string
+	}{
+		{"spots", map[string]float64{"k": 0.062, "f": 0.03}, "out_spots_f0.03_k0.062_du0.2_dv0.1_seed7"},
+		{"brusselator", map[string]float64{"b": 3, "a": 1}, "out_brusselator_a1_b3_du0.2_dv0.1_seed7"},
+		{"linear", nil, "out_linear_du0.2_dv0.1_seed7"},
+	}
+	for _, test := range outputs {
+		if got := OutputName("out", test.label, test.reaction, 0.2, 0.1, 7); got != test.want {
+			t.Errorf("OutputName gave %q, expected %q", got, test.want)
+		}
+	}
+
+	if got := formatParameters(map[string]float64{"k": 0.101, "f": 0.042}, "=", ", "); got != "f=0.042, k=0.101" {
+		t.Errorf("formatParameters gave %q", got)
+	}
+	if got := formatParameters(nil, "=", ", "); got != "" {
+		t.Errorf("formatParameters of no parameters gave %q", got)
+	}
+
+	ranges := []struct {
+		text     string
+		min, max float64
+		ok       bool
+	}{
+		{"0.01:0.1", 0.01, 0.1, true},
+		{"-1:2e-3", -1, 0.002, true},
+		{"0.1:0.01", 0.1, 0.01, true},
+		{"0.01", 0, 0, false},
+		{"0.01:0.1:0.2", 0, 0, false},
+		{"a:0.1", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, test := range ranges {
+		min, max, err := parseRange(test.text)
+		if (err == nil) != test.ok || min != test.min || max != test.max {
+			t.Errorf("parseRange(%q) gave %g, %g, %v", test.text, min, max, err)
+		}
+	}
+}
+
+func BenchmarkSerial(b *testing.B) {
+	board := InitialBoard(250, 250, 0.05, 0, nil)
+	for i := 0; i < b.N; i++ {
+		UpdateBoard(board, GrayScott{0.042, 0.101}, 0.2, 0.1, isotropicKernel, Dirichlet{})
+	}
+}
+
+func BenchmarkFlat(b *testing.B) {
+	current := GridFromBoard(InitialBoard(250, 250, 0.05, 0, nil))
+	next := NewGrid(250, 250)
+	params := Parameters{Model: GrayScott{0.042, 0.101}, PreyDiffusionRate: 0.2, PredatorDiffusionRate: 0.1, Kernels: isotropicKernel, Boundary: Dirichlet{}}
+	for i := 0; i < b.N; i++ {
+		UpdateGrid(current, next, params)
+	}
+}
+
+func BenchmarkParallel(b *testing.B) {
+	board := InitialBoard(250, 250, 0.05, 0, nil)
+	for i := 0; i < b.N; i++ {
+		UpdateBoardParallel(board, runtime.NumCPU(), GrayScott{0.042, 0.101}, 0.2, 0.1, isotropicKernel, Dirichlet{})
+	}
+}
+
+// boardsEqual compares every concentration exactly, since the parallel update must be bit for bit the same
+func boardsEqual(a, b Board) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for r := range a {
+		if len(a[r]) != len(b[r]) {
+			return false
+		}
+		for c := range a[r] {
+			if a[r][c] != b[r][c] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func randomBoard(numRows, numCols int, seed int64) Board {
+	rng := rand.New(rand.NewSource(seed))
+	board := InitializeBoard(numRows, numCols)
+	for r := range board {
+		for c := range board[r] {
+			board[r][c] = Cell{rng.Float64(), rng.Float64()}
+		}
+	}
+	return board
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Grid stores a board in one flat slice, row after row. The prey and predator concentrations of the
+// cell at row r and column c are Data[2*(r*NumCols+c)] and Data[2*(r*NumCols+c)+1].
+type Grid struct {
+	NumRows int
+	NumCols int
+	Data    []float64
+}
+
+// Parameters holds everything the flat engine needs to update a board by one generation.
+type Parameters struct {
+	Model                 ReactionModel
+	PreyDiffusionRate     float64
+	PredatorDiffusionRate float64
+	Kernels               Kernels
+	Boundary              Boundary
+	NumProcs              int     // number of row bands updated at once; 1 or less updates serially
+	Integrator            string  // euler, rk4 or semi-implicit; euler if empty
+	Dt                    float64 // time step of every generation; 1 if zero
+}
+
+// Input: a number of rows and a number of columns.
+// Return: a numRows * numCols Grid with all values initialized to zero.
+func NewGrid(numRows, numCols int) Grid {
+	return Grid{NumRows: numRows, NumCols: numCols, Data: make([]float64, 2*numRows*numCols)}
+}
+
+// Input: a Board (assumes rectangular).
+// Return: a Grid holding a copy of the board's concentrations.
+func GridFromBoard(b Board) Grid {
+
+	g := NewGrid(CountRows(b), CountCols(b))
+	for r := range b {
+		for c := range b[r] {
+			g.Data[g.index(r, c)] = b[r][c][0]
+			g.Data[g.index(r, c)+1] = b[r][c][1]
+		}
+	}
+	return g
+}
+
+// Board is a Grid method.
+// Return: a new Board holding a copy of the grid's concentrations, for the code that works on Boards.
+func (g Grid) Board() Board {
+
+	b := InitializeBoard(g.NumRows, g.NumCols)
+	for r := range b {
+		for c := range b[r] {
+			b[r][c] = g.At(r, c)
+		}
+	}
+	return b
+}
+
+// At is a Grid method.
+// Input: row/col values (r,c) on the grid.
+// Return: the cell at row r and column c.
+func (g Grid) At(r, c int) Cell {
+	i := g.index(r, c)
+	return Cell{g.Data[i], g.Data[i+1]}
+}
+
+// index returns the position in Data of the prey concentration of the cell at row r and column c.
+func (g Grid) index(r, c int) int {
+	return 2 * (r*g.NumCols + c)
+}
+
+// Input: an initial Board, a number of generations, how often to save a generation, the parameters
+// of the run and a function to save a generation with, which may be nil.
+// Return: the board after numGens generations, or an error if saveEvery is not positive, if the
+// parameters are not valid, if a generation holds a concentration that is not finite, or if save returns one.
+// Each generation advances time by params.Dt with params.Integrator. Only two Grids are ever
+// allocated for the board: each generation is written into the buffer holding the one before last,
+// and then the buffers swap. save is called with generation 0 and every saveEvery-th generation after
+// it, along with the generation just before it, which is still in the other buffer, or an empty Grid
+// for generation 0. Both grids are overwritten within two generations, so save must copy anything it
+// keeps, for example with Grid.Board.
+func SimulateGrayScottFlat(initialBoard Board, numGens, saveEvery int, params Parameters, save func(gen int, current, previous Grid) error) (Board, error) {
+
+	if saveEvery <= 0 {
+		return nil, errors.New("generations between saves must be positive")
+	}
+	if err := params.Kernels.Validate(); err != nil {
+		return nil, err
+	}
+	if err := CheckFinite(initialBoard); err != nil {
+		return nil, fmt.Errorf("initial board: %v", err)
+	}
+	current := GridFromBoard(initialBoard)
+	next := NewGrid(current.NumRows, current.NumCols)
+	step, err := newStepper(params, current.NumRows, current.NumCols)
+	if err != nil {
+		return nil, err
+	}
+
+	for gen := 0; gen <= numGens; gen++ {
+		if gen > 0 {
+			step(current, next)
+			current, next = next, current
+			if err := current.checkFinite(); err != nil {
+				return nil, fmt.Errorf("generation %d: %v; the run diverged, so try a smaller dt or another integrator", gen, err)
+			}
+		}
+		if save != nil && gen%saveEvery == 0 {
+			previous := next
+			if gen == 0 {
+				previous = Grid{}
+			}
+			if err := save(gen, current, previous); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return current.Board(), nil
+}
+
+// Input: the current Grid, a Grid of the same size to write into, and the parameters of the run.
+// Return: nothing, but next holds the generation after current by an explicit Euler step of params.Dt.
+// With a step of 1 it is bit for bit the one UpdateBoard would return. With more than one processor
+// the rows are split into bands as UpdateBoardParallel does.
+func UpdateGrid(current, next Grid, params Parameters) {
+	forBands(current.NumRows, params.NumProcs, func(start, end int) {
+		updateGridRows(current, next, start, end, &params)
+	})
+}
+
+// Input: the current and next Grids, the rows [start, end) to update and the parameters of the run.
+// Return: nothing, but the rows of next are set to their next generation. The sums are taken in the
+// same order as in UpdateCell, so the results match it exactly.
+func updateGridRows(current, next Grid, start, end int, params *Parameters) {
+
+	dt, reach := params.step(), params.reach()
+	for row := start; row < end; row++ {
+		for col := 0; col < current.NumCols; col++ {
+			diffusion, reactions := current.rates(row, col, reach, params)
+			newCell := SumCells(current.At(row, col), ScaleCell(diffusion, dt), ScaleCell(reactions, dt))
+
+			i := next.index(row, col)
+			next.Data[i] = newCell[0]
+			next.Data[i+1] = newCell[1]
+		}
+	}
+}
+
+// Input: a number of rows, a number of processors and a function that updates the rows [start, end).
+// Return: nothing, once update has run on one band of rows per processor, all at the same time.
+func forBands(numRows, numProcs int, update func(start, end int)) {
+
+	if numProcs > numRows {
+		numProcs = numRows
+	}
+	if numProcs <= 1 {
+		update(0, numRows)
+		return
+	}
+
+	finished := make(chan bool, numProcs)
+	chunkSize := numRows / numProcs
+	for i := 0; i < numProcs; i++ {
+		start := i * chunkSize
+		end := start + chunkSize
+		// the last processor takes the rows left over
+		if i == numProcs-1 {
+			end = numRows
+		}
+		go func(start, end int) {
+			update(start, end)
+			finished <- true
+		}(start, end)
+	}
+	for i := 0; i < numProcs; i++ {
+		<-finished
+	}
+}
+
+// reach is a Parameters method.
+// Return: the radius of the wider kernel, so cells further than that from the edge never reach off the grid.
+func (params Parameters) reach() int {
+	if params.Kernels[1].Radius() > params.Kernels[0].Radius() {
+		return params.Kernels[1].Radius()
+	}
+	return params.Kernels[0].Radius()
+}
+
+// rates returns the change per unit of time of the cell at row and col due to diffusion and due to
+// reactions, where reach is params.reach().
+func (g Grid) rates(row, col, reach int, params *Parameters) (Cell, Cell) {
+
+	interior := row >= reach && row < g.NumRows-reach && col >= reach && col < g.NumCols-reach
+
+	data := g.Data
+	var diffusion Cell
+	for species, kernel := range params.Kernels {
+		radius := kernel.Radius()
+		for kernelRows := -radius; kernelRows <= radius; kernelRows++ {
+			for kernelCols := -radius; kernelCols <= radius; kernelCols++ {
+				weight := kernel[kernelRows+radius][kernelCols+radius]
+				if interior {
+					diffusion[species] += data[g.index(row+kernelRows, col+kernelCols)+species] * weight
+				} else {
+					neighbour := g.cellBeyond(params.Boundary, row+kernelRows, col+kernelCols)
+					diffusion[species] += neighbour[species] * weight
+				}
+			}
+		}
+	}
+	diffusion[0] *= params.PreyDiffusionRate
+	diffusion[1] *= params.PredatorDiffusionRate
+
+	return diffusion, params.Model.Change(g.At(row, col), row, col)
+}
+
+// cellBeyond returns the cell at row r and column c, which may lie off the grid, where the boundary decides what is seen.
+func (g Grid) cellBeyond(boundary Boundary, r, c int) Cell {
+
+	if r >= 0 && r < g.NumRows && c >= 0 && c < g.NumCols {
+		return g.At(r, c)
+	}
+	row, col, onBoard := boundary.Position(r, c, g.NumRows, g.NumCols)
+	if !onBoard {
+		return boundary.Outside()
+	}
+	return g.At(row, col)
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/cmplx"
+	"strings"
+)
+
+// IntegratorNames lists the integrators the flat engine understands.
+// euler is the explicit Euler step the program has always taken, rk4 the classical fourth order
+// Runge-Kutta method, and semi-implicit takes the reactions explicitly and solves for the diffusion
+// implicitly with a Fourier transform, so its time step is not limited by the diffusion rates.
+var IntegratorNames = []string{"euler", "rk4", "semi-implicit"}
+
+// step is a Parameters method.
+// Return: the time step of every generation, which is 1 unless Dt is set.
+func (params Parameters) step() float64 {
+	if params.Dt == 0 {
+		return 1
+	}
+	return params.Dt
+}
+
+// Input: the parameters of a run and the size of its board.
+// Return: a function that writes the generation after current into next with params.Integrator,
+// or an error if the integrator is unknown or the time step is not stable.
+func newStepper(params Parameters, numRows, numCols int) (func(current, next Grid), error) {
+
+	if params.step() <= 0 || math.IsNaN(params.Dt) || math.IsInf(params.Dt, 0) {
+		return nil, fmt.Errorf("dt must be positive, not %g", params.Dt)
+	}
+
+	switch params.Integrator {
+	case "", "euler", "rk4":
+		if err := CheckStability(params); err != nil {
+			return nil, err
+		}
+		if params.Integrator == "rk4" {
+			return newRK4(params, numRows, numCols), nil
+		}
+		return func(current, next Grid) { UpdateGrid(current, next, params) }, nil
+	case "semi-implicit":
+		return newSemiImplicit(params, numRows, numCols)
+	default:
+		return nil, errors.New("integrator must be " + strings.Join(IntegratorNames, ", "))
+	}
+}
+
+// Input: the current Grid, a Grid of the same size to write into, and the parameters of the run.
+// Return: nothing, but out holds the change per unit of time of every cell of current.
+func derivative(current, out Grid, params Parameters) {
+
+	reach := params.reach()
+	forBands(current.NumRows, params.NumProcs, func(start, end int) {
+		for row := start; row < end; row++ {
+			for col := 0; col < current.NumCols; col++ {
+				diffusion, reactions := current.rates(row, col, reach, &params)
+				i := out.index(row, col)
+				out.Data[i] = diffusion[0] + reactions[0]
+				out.Data[i+1] = diffusion[1] + reactions[1]
+			}
+		}
+	})
+}
+
+// Input: the parameters of a run and the size of its board.
+// Return: a function that takes one step of the classical Runge-Kutta method. The four stages and the
+// board they are taken at are allocated once here, so no step allocates.
+func newRK4(params Parameters, numRows, numCols int) func(current, next Grid) {
+
+	dt := params.step()
+	k1, k2, k3, k4 := NewGrid(numRows, numCols), NewGrid(numRows, numCols), NewGrid(numRows, numCols), NewGrid(numRows, numCols)
+	stage := NewGrid(numRows, numCols)
+
+	// advance sets stage to current moved by h along k
+	advance := func(current, k Grid, h float64) {
+		for i, value := range current.Data {
+			stage.Data[i] = value + h*k.Data[i]
+		}
+	}
+
+	return func(current, next Grid) {
+		derivative(current, k1, params)
+		advance(current, k1, dt/2)
+		derivative(stage, k2, params)
+		advance(current, k2, dt/2)
+		derivative(stage, k3, params)
+		advance(current, k3, dt)
+		derivative(stage, k4, params)
+		for i, value := range current.Data {
+			next.Data[i] = value + dt/6*(k1.Data[i]+2*k2.Data[i]+2*k3.Data[i]+k4.Data[i])
+		}
+	}
+}
+
+// Input: the parameters of a run and the size of its board.
+// Return: a function that takes one semi-implicit step, or an error if the boundary is not periodic
+// or the diffusion can't be solved for.
+// Each step first adds dt times the reactions to every cell, and then solves
+// (1 - dt*D*L) u_next = u + dt*R(u) for each species, where L is the species' kernel and D its
+// diffusion rate. On a periodic board every kernel is diagonal in Fourier space, so the solve is
+// one division per frequency between a forward and an inverse transform.
+func newSemiImplicit(params Parameters, numRows, numCols int) (func(current, next Grid), error) {
+
+	if _, ok := params.Boundary.(Periodic); !ok {
+		return nil, errors.New("the semi-implicit integrator needs a periodic boundary")
+	}
+
+	dt := params.step()
+	rates := Cell{params.PreyDiffusionRate, params.PredatorDiffusionRate}
+	var denominators [2][]complex128
+	for species, kernel := range params.Kernels {
+		denominators[species] = make([]complex128, numRows*numCols)
+		for p := 0; p < numRows; p++ {
+			for q := 0; q < numCols; q++ {
+				d := 1 - complex(dt*rates[species], 0)*kernelSymbol(kernel, 2*math.Pi*float64(p)/float64(numRows), 2*math.Pi*float64(q)/float64(numCols))
+				if cmplx.Abs(d) < 1e-12 {
+					return nil, fmt.Errorf("%s diffusion can't be solved for with dt %g", []string{"prey", "predator"}[species], dt)
+				}
+				denominators[species][p*numCols+q] = d
+			}
+		}
+	}
+
+	transform := newFFT2(numRows, numCols)
+	spectrum := make([]complex128, numRows*numCols)
+
+	return func(current, next Grid) {
+		// the reactions are taken explicitly, straight into next
+		forBands(numRows, params.NumProcs, func(start, end int) {
+			for row := start; row < end; row++ {
+				for col := 0; col < numCols; col++ {
+					newCell := SumCells(current.At(row, col), ScaleCell(params.Model.Change(current.At(row, col), row, col), dt))
+					i := next.index(row, col)
+					next.Data[i] = newCell[0]
+					next.Data[i+1] = newCell[1]
+				}
+			}
+		})
+
+		for species := range params.Kernels {
+			for i := range spectrum {
+				spectrum[i] = complex(next.Data[2*i+species], 0)
+			}
+			transform.transform(spectrum, false)
+			for i, d := range denominators[species] {
+				spectrum[i] /= d
+			}
+			transform.transform(spectrum, true)
+			for i, value := range spectrum {
+				next.Data[2*i+species] = real(value)
+			}
+		}
+	}, nil
+}
+
+// Input: a kernel and the angular frequencies down the rows and across the columns of a wave.
+// Return: the factor the kernel multiplies the wave by, sum of w[a][b]*exp(i*(a*thetaRow + b*thetaCol))
+// over the offsets (a, b) from the centre. For a kernel that is symmetric about its centre it is real.
+func kernelSymbol(kernel Kernel, thetaRow, thetaCol float64) complex128 {
+
+	radius := kernel.Radius()
+	var symbol complex128
+	for a := -radius; a <= radius; a++ {
+		for b := -radius; b <= radius; b++ {
+			symbol += complex(kernel[a+radius][b+radius], 0) * cmplx.Exp(complex(0, float64(a)*thetaRow+float64(b)*thetaCol))
+		}
+	}
+	return symbol
+}
+
+// CheckStability takes the parameters of a run.
+// It returns an error if the explicit integrator would amplify some wave of the diffusion term, so the
+// run would blow up, along with the largest stable time step. This is the von Neumann, or CFL, condition
+// of the diffusion alone: the reactions can still make a run diverge, which SimulateGrayScottFlat
+// catches once a concentration stops being finite. The semi-implicit integrator is always stable.
+func CheckStability(params Parameters) error {
+
+	if params.Integrator == "semi-implicit" {
+		return nil
+	}
+	dt := params.step()
+	rates := Cell{params.PreyDiffusionRate, params.PredatorDiffusionRate}
+	for species, kernel := range params.Kernels {
+		symbols := sampleSymbol(kernel)
+		stable := func(dt float64) bool {
+			for _, symbol := range symbols {
+				if cmplx.Abs(amplification(params.Integrator, complex(dt*rates[species], 0)*symbol)) > 1+1e-12 {
+					return false
+				}
+			}
+			return true
+		}
+		if stable(dt) {
+			continue
+		}
+
+		// the largest stable step lies between 0 and dt, so halve the gap until it is found
+		low, high := 0.0, dt
+		for i := 0; i < 60; i++ {
+			if middle := (low + high) / 2; stable(middle) {
+				low = middle
+			} else {
+				high = middle
+			}
+		}
+		name := params.Integrator
+		if name == "" {
+			name = "euler"
+		}
+		speciesName := []string{"prey", "predator"}[species]
+		if low == 0 {
+			return fmt.Errorf("%s is unstable for %s diffusion at any dt; use the semi-implicit integrator", name, speciesName)
+		}
+		return fmt.Errorf("%s is unstable for %s diffusion at dt %g; dt must be at most %.4g", name, speciesName, dt, low)
+	}
+	return nil
+}
+
+// Input: a kernel.
+// Return: its symbol at 64 x 64 frequencies spread evenly over every direction and wavelength.
+func sampleSymbol(kernel Kernel) []complex128 {
+
+	const samples = 64
+	symbols := make([]complex128, 0, samples*samples)
+	for p := 0; p < samples; p++ {
+		for q := 0; q < samples; q++ {
+			symbols = append(symbols, kernelSymbol(kernel, 2*math.Pi*float64(p)/samples, 2*math.Pi*float64(q)/samples))
+		}
+	}
+	return symbols
+}
+
+// Input: the name of an explicit integrator and dt times an eigenvalue of the linear problem.
+// Return: the factor one step of the integrator multiplies that eigenvector by.
+func amplification(integrator string, z complex128) complex128 {
+	if integrator == "rk4" {
+		return 1 + z + z*z/2 + z*z*z/6 + z*z*z*z/24
+	}
+	return 1 + z
+}
+
+// CheckFinite takes a Board.
+// It returns an error naming the first cell with a concentration that is NaN or infinite, if there is one.
+func CheckFinite(b Board) error {
+
+	for r := range b {
+		for c := range b[r] {
+			if err := finiteCell(b[r][c], r, c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkFinite is the Grid version of CheckFinite.
+func (g Grid) checkFinite() error {
+
+	for i, value := range g.Data {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return finiteCell(g.At(i/2/g.NumCols, i/2%g.NumCols), i/2/g.NumCols, i/2%g.NumCols)
+		}
+	}
+	return nil
+}
+
+// Input: a Cell and its row and column.
+// Return: an error if one of its concentrations is NaN or infinite.
+func finiteCell(cell Cell, r, c int) error {
+	for species, value := range cell {
+		if math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("%s concentration at row %d, column %d is %g", []string{"prey", "predator"}[species], r, c, value)
+		}
+	}
+	return nil
+}
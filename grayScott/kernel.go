@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Kernel is a square diffusion stencil with an odd number of rows. The weight of the cell i rows
+// and j columns away from the centre is kernel[i+radius][j+radius], where radius is kernel.Radius().
+// The generators below scale their kernels so the centre weight is -1, as in the original 3x3
+// kernel, so a diffusion rate means about the same whichever kernel it is used with.
+type Kernel [][]float64
+
+// Kernels holds the diffusion kernel of each species, indexed like the concentrations of a Cell:
+// prey first, then predator. Different kernels give anisotropic or directionally biased diffusion.
+type Kernels [2]Kernel
+
+// KernelNames lists the generated kernels understood by KernelFromName.
+var KernelNames = []string{"isotropic", "five-point", "gaussian"}
+
+// Radius is a Kernel method.
+// Return: the number of cells the kernel reaches from its centre in every direction.
+func (k Kernel) Radius() int {
+	return len(k) / 2
+}
+
+// Validate is a Kernel method.
+// Return: an error unless the kernel is square with an odd number of rows and its weights sum to
+// zero, which diffusion needs so that a board at one concentration throughout stays that way.
+func (k Kernel) Validate() error {
+
+	if len(k)%2 == 0 {
+		return errors.New("kernel must have an odd number of rows")
+	}
+	sum, size := 0.0, 0.0
+	for _, row := range k {
+		if len(row) != len(k) {
+			return errors.New("kernel must be square")
+		}
+		for _, weight := range row {
+			sum += weight
+			size += math.Abs(weight)
+		}
+	}
+	// a little room is left for weights such as 0.05 that floating point can't hold exactly
+	if math.Abs(sum) > 1e-9*size {
+		return fmt.Errorf("kernel weights must sum to zero, not %g", sum)
+	}
+	return nil
+}
+
+// Validate is a Kernels method.
+// Return: the error of the first kernel that is not valid, if there is one.
+func (k Kernels) Validate() error {
+
+	for species, kernel := range k {
+		if err := kernel.Validate(); err != nil {
+			return fmt.Errorf("%s %v", []string{"prey", "predator"}[species], err)
+		}
+	}
+	return nil
+}
+
+// SameKernel takes a kernel.
+// It returns Kernels that diffuse both species with it.
+func SameKernel(k Kernel) Kernels {
+	return Kernels{k, k}
+}
+
+// FivePointLaplacian returns the 3x3 kernel of the 5-point Laplacian, which weights the four
+// sides of a cell 0.25 and ignores the corners.
+func FivePointLaplacian() Kernel {
+	return Kernel{
+		{0, .25, 0},
+		{.25, -1, .25},
+		{0, .25, 0},
+	}
+}
+
+// IsotropicLaplacian returns the 3x3 kernel of the 9-point isotropic Laplacian, which weights the
+// sides of a cell 0.2 and the corners 0.05. It is the kernel this program has always used.
+func IsotropicLaplacian() Kernel {
+	return Kernel{
+		{.05, .2, .05},
+		{.2, -1, .2},
+		{.05, .2, .05},
+	}
+}
+
+// GaussianKernel takes a radius and a standard deviation in cells.
+// It returns a (2*radius+1) x (2*radius+1) kernel that weights every other cell by a Gaussian of its
+// distance from the centre, scaled so those weights sum to 1, with -1 at the centre.
+func GaussianKernel(radius int, sigma float64) (Kernel, error) {
+
+	if radius < 1 || sigma <= 0 {
+		return nil, errors.New("gaussian kernel needs a positive radius and sigma")
+	}
+
+	k := make(Kernel, 2*radius+1)
+	total := 0.0
+	for i := range k {
+		k[i] = make([]float64, 2*radius+1)
+		for j := range k[i] {
+			if i == radius && j == radius {
+				continue
+			}
+			dr, dc := float64(i-radius), float64(j-radius)
+			k[i][j] = math.Exp(-(dr*dr + dc*dc) / (2 * sigma * sigma))
+			total += k[i][j]
+		}
+	}
+	for i := range k {
+		for j := range k[i] {
+			k[i][j] /= total
+		}
+	}
+	k[radius][radius] = -1
+	return k, nil
+}
+
+// KernelFromName takes the name of a kernel in KernelNames, or its weights written row by row as
+// "a,b,c;d,e,f;g,h,i", and the radius and standard deviation of a gaussian kernel.
+// It returns the kernel, after checking it is valid.
+func KernelFromName(name string, radius int, sigma float64) (Kernel, error) {
+
+	var k Kernel
+	var err error
+	switch name {
+	case "isotropic":
+		k = IsotropicLaplacian()
+	case "five-point":
+		k = FivePointLaplacian()
+	case "gaussian":
+		k, err = GaussianKernel(radius, sigma)
+	default:
+		if !strings.Contains(name, ",") {
+			return nil, errors.New("kernel must be " + strings.Join(KernelNames, ", ") + " or a list of weights a,b,c;d,e,f;g,h,i")
+		}
+		k, err = parseKernel(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := k.Validate(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Input: the weights of a kernel written row by row as "a,b,c;d,e,f;g,h,i".
+// Return: the kernel they describe.
+func parseKernel(text string) (Kernel, error) {
+
+	rows := strings.Split(text, ";")
+	k := make(Kernel, len(rows))
+	for i, row := range rows {
+		for _, field := range strings.Split(row, ",") {
+			weight, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, fmt.Errorf("kernel weight %q is not a number", field)
+			}
+			k[i] = append(k[i], weight)
+		}
+	}
+	return k, nil
+}
@@ -4,77 +4,395 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
 	"gifhelper"
+	"image"
+	"io"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func main() {
-	numRows := 250
-	numCols := 250
+	presetName := flag.String("preset", "default", "named parameters: "+strings.Join(PresetNames(), ", "))
+	modelName := flag.String("model", "gray-scott", "reaction model: "+strings.Join(ModelNames, ", "))
+	reactionList := flag.String("reaction", "", "parameters of the reaction model as name=value,...; the model's defaults by default")
+	feedRate := flag.Float64("f", 0, "feed rate of gray-scott, the preset's by default")
+	killRate := flag.Float64("k", 0, "kill rate of gray-scott, the preset's by default")
+	pearson := flag.Bool("pearson", false, "vary f from bottom to top and k from left to right over f-range and k-range, as in Pearson's parameter maps")
+	feedFieldFile := flag.String("feed-field", "", "CSV file with a feed rate for every cell, or an image whose brightness spans f-range")
+	killFieldFile := flag.String("kill-field", "", "CSV file with a kill rate for every cell, or an image whose brightness spans k-range")
+	feedRange := flag.String("f-range", "0.01:0.1", "range of feed rates min:max for pearson and feed-field images")
+	killRange := flag.String("k-range", "0.045:0.07", "range of kill rates min:max for pearson and kill-field images")
+	preyDiffusionRate := flag.Float64("du", 0, "prey diffusion rate, the preset's by default")
+	predatorDiffusionRate := flag.Float64("dv", 0, "predator diffusion rate, the preset's by default")
+	kernelName := flag.String("kernel", "isotropic", "diffusion kernel: "+strings.Join(KernelNames, ", ")+" or weights a,b,c;d,e,f;g,h,i, which must sum to zero")
+	predatorKernelName := flag.String("predator-kernel", "", "diffusion kernel of the predators, the same as the prey's by default")
+	kernelRadius := flag.Int("kernel-radius", 2, "radius of a gaussian kernel in cells")
+	kernelSigma := flag.Float64("kernel-sigma", 1, "standard deviation of a gaussian kernel in cells")
+	boundaryName := flag.String("boundary", "dirichlet", "boundary condition: "+strings.Join(BoundaryNames, ", "))
+	boundaryPrey := flag.Float64("boundary-prey", 0, "prey concentration beyond the edges for dirichlet")
+	boundaryPredator := flag.Float64("boundary-predator", 0, "predator concentration beyond the edges for dirichlet")
+	numRows := flag.Int("height", 250, "number of rows of the board")
+	numCols := flag.Int("width", 250, "number of columns of the board")
+	frac := flag.Float64("frac", 0.05, "fraction of each side covered by the central square of predators")
+	noise := flag.Float64("noise", 0, "largest random predator concentration added to every cell")
+	seed := flag.Int64("seed", 0, "seed for the noise, 0 picks one from the clock")
+	numGens := flag.Int("gens", 20000, "number of generations")
+	integrator := flag.String("integrator", "euler", "time integrator: "+strings.Join(IntegratorNames, ", ")+"; semi-implicit needs a periodic boundary")
+	dt := flag.Float64("dt", 1, "time step of every generation")
+	numProcs := flag.Int("procs", runtime.NumCPU(), "number of processors, 1 runs the serial update")
+	n := flag.Int("every", 100, "draw every nth generation")
+	cellWidth := flag.Int("cell-width", 1, "width of each cell in pixels")
+	output := flag.String("out", "Gray-Scott", "base name of the GIF, which the preset, parameters and seed are added to")
+	save := flag.String("save", "", "file to save the last board and the parameters of the run to, as CSV if it ends in .csv and as a binary .npy file otherwise")
+	resumeFrom := flag.String("resume-from", "", "board saved with -save to run on from, with the parameters it was saved with unless they are given again")
+	initImage := flag.String("init-image", "", "image to seed the board from, with predators wherever it is dark, instead of the central square")
+	metricsFile := flag.String("metrics", "", "CSV file to write the mass, spots, dominant wavelength and change of every drawn generation to")
+	spotThreshold := flag.Float64("spot-threshold", 0.25, "predator concentration above which a cell is part of a spot")
+	steadyTolerance := flag.Float64("steady-tol", 1e-6, "change of a concentration per generation below which the run is steady")
+	stopSteady := flag.Bool("stop-steady", false, "stop the run at the first drawn generation that is steady")
+	flag.Parse()
 
-	initialBoard := InitializeBoard(numRows, numCols)
+	// a resumed run takes up the parameters it was saved with, and its board sets the size
+	startGen := 0
+	var savedBoard Board
+	if *resumeFrom != "" {
+		if *initImage != "" {
+			fmt.Println("Error: a run can't both resume from a board and seed one from an image")
+			return
+		}
+		board, metadata, err := LoadBoard(*resumeFrom)
+		if err == nil {
+			startGen, err = resumeFlags(metadata)
+		}
+		if err != nil {
+			fmt.Println("Error resuming:", err)
+			return
+		}
+		savedBoard = board
+		*numRows, *numCols = CountRows(board), CountCols(board)
+		fmt.Printf("Resuming from generation %d of %s\n", startGen, *resumeFrom)
+	}
 
-	frac := 0.05 // tells us what percentage of interior cells to color with predators
+	preset, ok := Presets[*presetName]
+	if !ok {
+		fmt.Println("Preset must be one of", strings.Join(PresetNames(), ", "))
+		return
+	}
 
-	// how many predator rows and columns are there?
-	predRows := frac * float64(numRows)
-	predCols := frac * float64(numCols)
+	// flags given on the command line override the preset
+	rateFlags := map[string]*float64{"f": feedRate, "k": killRate, "du": preyDiffusionRate, "dv": predatorDiffusionRate}
+	given := make(map[string]float64)
+	flag.Visit(func(f *flag.Flag) {
+		if rate, ok := rateFlags[f.Name]; ok {
+			given[f.Name] = *rate
+		}
+	})
+	preset = preset.Override(given)
 
-	midRow := numRows / 2
-	midCol := numCols / 2
+	if *numRows <= 0 || *numCols <= 0 || *numGens <= 0 || *n <= 0 || *cellWidth <= 0 || *numProcs <= 0 {
+		fmt.Println("Error: height, width, gens, every, cell-width and procs must be positive")
+		return
+	}
+	// the flat engine takes a dt of 0 as 1, so one given on the command line must be set and positive
+	if !(*dt > 0) {
+		fmt.Println("Error: dt must be positive")
+		return
+	}
+	if *frac < 0 || *frac > 1 || *noise < 0 {
+		fmt.Println("Error: frac must be between 0 and 1 and noise can't be negative")
+		return
+	}
+	preyKernel, err := KernelFromName(*kernelName, *kernelRadius, *kernelSigma)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	kernels := SameKernel(preyKernel)
+	if *predatorKernelName != "" {
+		kernels[1], err = KernelFromName(*predatorKernelName, *kernelRadius, *kernelSigma)
+		if err != nil {
+			fmt.Println("Error in predator kernel:", err)
+			return
+		}
+	}
+	boundary, err := BoundaryFromName(*boundaryName, *boundaryPrey, *boundaryPredator)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
 
-	// a little for loop to fill predators
-	for r := midRow - int(predRows/2); r < midRow+int(predRows/2); r++ {
-		for c := midCol - int(predCols/2); c < midCol+int(predCols/2); c++ {
-			initialBoard[r][c][1] = 1.0
+	// gray-scott takes its feed and kill rates from the preset, unless they are among the reaction parameters
+	values, err := ParseReactionParameters(*reactionList)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	label := *modelName
+	if *modelName == "gray-scott" {
+		label = *presetName
+		if _, ok := values["f"]; !ok {
+			values["f"] = preset.FeedRate
+		}
+		if _, ok := values["k"]; !ok {
+			values["k"] = preset.KillRate
 		}
 	}
+	reaction, err := ReactionParameters(*modelName, values)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	model, _ := ModelFromName(*modelName, reaction)
+
+	// feed and kill rates that vary across the board replace the model's own
+	if *pearson || *feedFieldFile != "" || *killFieldFile != "" {
+		if *modelName != "gray-scott" {
+			fmt.Println("Error: feed and kill rate fields need the gray-scott model")
+			return
+		}
+		fMin, fMax, err1 := parseRange(*feedRange)
+		kMin, kMax, err2 := parseRange(*killRange)
+		if err1 != nil || err2 != nil {
+			fmt.Println("Error: f-range and k-range must be min:max")
+			return
+		}
 
-	// make prey concentration 1 at every cell
-	for i := range initialBoard {
-		for j := range initialBoard[i] {
-			initialBoard[i][j][0] = 1.0
+		field := GrayScottField{Feed: Constant(reaction["f"]), Kill: Constant(reaction["k"])}
+		if *pearson {
+			field.Feed, field.Kill = PearsonMap(*numRows, *numCols, fMin, fMax, kMin, kMax)
+			label = fmt.Sprintf("pearson_f%g-%g_k%g-%g", fMin, fMax, kMin, kMax)
+			delete(reaction, "f")
+			delete(reaction, "k")
+		}
+		if *feedFieldFile != "" {
+			feed, err := LoadField(*feedFieldFile, *numRows, *numCols, fMin, fMax)
+			if err != nil {
+				fmt.Println("Error loading feed field:", err)
+				return
+			}
+			field.Feed = feed
+			label += "_feed-field"
+			delete(reaction, "f")
 		}
+		if *killFieldFile != "" {
+			kill, err := LoadField(*killFieldFile, *numRows, *numCols, kMin, kMax)
+			if err != nil {
+				fmt.Println("Error loading kill field:", err)
+				return
+			}
+			field.Kill = kill
+			label += "_kill-field"
+			delete(reaction, "k")
+		}
+		model = field
 	}
 
-	// let's set some parameters too
-	numGens := 20000 // number of iterations
-	feedRate := 0.042
-	killRate := 0.101
+	// every run prints its seed so it can be made again
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Using seed %d\n", *seed)
+	fmt.Printf("Running %s (%s) %s, prey diffusion %g, predator diffusion %g, %s with dt %g\n", *modelName, label,
+		formatParameters(reaction, "=", ", "), preset.PreyDiffusionRate, preset.PredatorDiffusionRate, *integrator, *dt)
 
-	preyDiffusionRate := 0.2 // prey are twice as fast at running
-	predatorDiffusionRate := 0.1
+	initialBoard := savedBoard
+	if *initImage != "" {
+		initialBoard, err = BoardFromImage(*initImage, *numRows, *numCols, *noise, rand.New(rand.NewSource(*seed)))
+		if err != nil {
+			fmt.Println("Error loading initial board:", err)
+			return
+		}
+	} else if initialBoard == nil {
+		initialBoard = InitialBoard(*numRows, *numCols, *frac, *noise, rand.New(rand.NewSource(*seed)))
+	}
 
-	// let's declare kernel
-	var kernel [3][3]float64
-	kernel[0][0] = .05
-	kernel[0][1] = .2
-	kernel[0][2] = .05
-	kernel[1][0] = .2
-	kernel[1][1] = -1.0
-	kernel[1][2] = .2
-	kernel[2][0] = .05
-	kernel[2][1] = .2
-	kernel[2][2] = .05
+	params := Parameters{
+		Model:                 model,
+		PreyDiffusionRate:     preset.PreyDiffusionRate,
+		PredatorDiffusionRate: preset.PredatorDiffusionRate,
+		Kernels:               kernels,
+		Boundary:              boundary,
+		NumProcs:              *numProcs,
+		Integrator:            *integrator,
+		Dt:                    *dt,
+	}
+
+	// the metrics are worked out from the drawn generations, which is also when a steady state is looked
+	// for by comparing each of them with the generation just before it
+	var metrics *MetricsWriter
+	if *metricsFile != "" || *stopSteady {
+		var out io.Writer = io.Discard
+		if *metricsFile != "" {
+			file, err := os.Create(*metricsFile)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			defer file.Close()
+			out = file
+		}
+		metrics, err = NewMetricsWriter(out, *spotThreshold, *steadyTolerance, boundary)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
 
 	// let's simulate Gray-Scott!
-	// result will be a collection of Boards corresponding to each generation.
-	boards := SimulateGrayScott(initialBoard, numGens, feedRate, killRate, preyDiffusionRate, predatorDiffusionRate, kernel)
+	// only every nth generation is drawn, as soon as it is reached, so no other board is ever kept.
+	imageList := make([]image.Image, 0)
+	lastGen, lastBoard := 0, initialBoard
+	finalBoard, err := SimulateGrayScottFlat(initialBoard, *numGens, *n, params, func(gen int, current, previous Grid) error {
+		board := current.Board()
+		imageList = append(imageList, DrawBoard(board, *cellWidth))
+		lastGen, lastBoard = gen, board
+		if metrics == nil {
+			return nil
+		}
+		var previousBoard Board
+		if gen > 0 {
+			previousBoard = previous.Board()
+		}
+		m, err := metrics.Record(startGen+gen, board, previousBoard)
+		if err != nil {
+			return err
+		}
+		if *stopSteady && m.Steady {
+			return errSteady
+		}
+		return nil
+	})
+	if err == errSteady {
+		fmt.Printf("Steady state reached at generation %d\n", startGen+lastGen)
+		finalBoard, *numGens = lastBoard, lastGen
+	} else if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if metrics != nil {
+		if err := metrics.Flush(); err != nil {
+			fmt.Println("Error writing metrics:", err)
+			return
+		}
+	}
 
-	fmt.Println("Done with simulation!")
+	fmt.Println("Done with simulation! Boards drawn! Now draw GIF.")
 
-	// we will draw what we have generated.
-	fmt.Println("Drawing boards to file.")
+	if *save != "" {
+		if err := SaveBoard(*save, finalBoard, runMetadata(startGen+*numGens, preset)); err != nil {
+			fmt.Println("Error saving board:", err)
+			return
+		}
+		fmt.Printf("Board at generation %d saved: %s\n", startGen+*numGens, *save)
+	}
+	if startGen > 0 {
+		label += fmt.Sprintf("_from%d", startGen)
+	}
 
-	//for the visualization, we are only going to draw every nth board to be more efficient
-	n := 100
+	outFile := OutputName(*output, label, reaction, preset.PreyDiffusionRate, preset.PredatorDiffusionRate, *seed)
+	gifhelper.ImagesToGIF(imageList, outFile) // code is given
+	fmt.Println("GIF drawn:", outFile)
+}
 
-	cellWidth := 1 // each cell is 1 pixel
+// errSteady stops a run with -stop-steady once it is steady.
+var errSteady = errors.New("steady state reached")
 
-	imageList := DrawBoards(boards, cellWidth, n)
-	fmt.Println("Boards drawn! Now draw GIF.")
+// runFlags are the flags that only concern one run, so they are not saved with its board.
+var runFlags = map[string]bool{
+	"gens": true, "procs": true, "every": true, "cell-width": true, "out": true,
+	"save": true, "resume-from": true, "init-image": true,
+	"metrics": true, "spot-threshold": true, "steady-tol": true, "stop-steady": true,
+}
 
-	outFile := "Gray-Scott"
-	gifhelper.ImagesToGIF(imageList, outFile) // code is given
-	fmt.Println("GIF drawn!")
+// Input: the generation the board is saved at and the preset after the command line overrode it.
+// Return: the metadata to save with the board: the generation, and the value of every flag but runFlags.
+// The rates the preset settled on are saved as the flags that set them, so a resumed run doesn't
+// depend on the presets staying the same.
+func runMetadata(generation int, preset Preset) map[string]string {
+
+	metadata := map[string]string{"generation": strconv.Itoa(generation)}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !runFlags[f.Name] {
+			metadata[f.Name] = f.Value.String()
+		}
+	})
+	metadata["f"] = strconv.FormatFloat(preset.FeedRate, 'g', -1, 64)
+	metadata["k"] = strconv.FormatFloat(preset.KillRate, 'g', -1, 64)
+	metadata["du"] = strconv.FormatFloat(preset.PreyDiffusionRate, 'g', -1, 64)
+	metadata["dv"] = strconv.FormatFloat(preset.PredatorDiffusionRate, 'g', -1, 64)
+	return metadata
+}
+
+// Input: the metadata saved with a board.
+// Return: the generation the board was saved at, after every flag in the metadata that was not given
+// on the command line is set to its saved value.
+func resumeFlags(metadata map[string]string) (int, error) {
+
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, value := range metadata {
+		if given[name] || runFlags[name] || flag.Lookup(name) == nil {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			return 0, fmt.Errorf("saved parameter %s: %v", name, err)
+		}
+	}
+
+	if metadata["generation"] == "" {
+		return 0, nil
+	}
+	generation, err := strconv.Atoi(metadata["generation"])
+	if err != nil || generation < 0 {
+		return 0, fmt.Errorf("saved generation %q is not a whole number", metadata["generation"])
+	}
+	return generation, nil
+}
+
+// OutputName takes the base name of the output, the name of the preset or model, the parameters of the
+// reaction model, the diffusion rates and the seed of the run.
+// It returns a file name, without extension, that records all of them so a run can be told apart from others.
+func OutputName(base, label string, reaction map[string]float64, preyDiffusionRate, predatorDiffusionRate float64, seed int64) string {
+
+	name := base + "_" + label
+	if len(reaction) > 0 {
+		name += "_" + formatParameters(reaction, "", "_")
+	}
+	return fmt.Sprintf("%s_du%g_dv%g_seed%d", name, preyDiffusionRate, predatorDiffusionRate, seed)
+}
+
+// Input: a range written as min:max.
+// Return: its two ends.
+func parseRange(text string) (float64, float64, error) {
+
+	parts := strings.Split(text, ":")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("range %q must be min:max", text)
+	}
+	min, err1 := strconv.ParseFloat(parts[0], 64)
+	max, err2 := strconv.ParseFloat(parts[1], 64)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("range %q must be min:max", text)
+	}
+	return min, max, nil
+}
+
+// Input: the parameters of a reaction model, the text between a name and its value, and the text between parameters.
+// Return: the parameters written out in alphabetical order, such as f0.042_k0.101.
+func formatParameters(reaction map[string]float64, equals, separator string) string {
+
+	parts := make([]string, 0, len(reaction))
+	for _, name := range sortedKeys(reaction) {
+		parts = append(parts, fmt.Sprintf("%s%s%g", name, equals, reaction[name]))
+	}
+	return strings.Join(parts, separator)
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReactionModel gives the change in the concentrations of a cell due to the reactions between its
+// two species. The first species is the prey, or activator, and the second the predator, or inhibitor.
+// Change is also given the row and column of the cell, so a model's parameters can vary across the board.
+type ReactionModel interface {
+	Change(currentCell Cell, row, col int) Cell
+}
+
+// GrayScott is the Gray-Scott model: prey are fed at FeedRate and eaten by pairs of predators,
+// which die at KillRate.
+type GrayScott struct {
+	FeedRate float64
+	KillRate float64
+}
+
+// FitzHughNagumo is the FitzHugh-Nagumo model of an excitable medium: the activator u grows as
+// Gamma*(u - u^3 - v), and the inhibitor v follows it at rate Epsilon as Gamma*Epsilon*(u - A1*v - A0).
+type FitzHughNagumo struct {
+	Epsilon float64
+	A0      float64
+	A1      float64
+	Gamma   float64
+}
+
+// Brusselator is the Brusselator model of an autocatalytic reaction: u changes by
+// Gamma*(A - (B+1)*u + u^2*v) and v by Gamma*(B*u - u^2*v).
+type Brusselator struct {
+	A     float64
+	B     float64
+	Gamma float64
+}
+
+// Schnakenberg is the Schnakenberg model, the simplest reaction with a Turing instability:
+// u changes by Gamma*(A - u + u^2*v) and v by Gamma*(B - u^2*v).
+type Schnakenberg struct {
+	A     float64
+	B     float64
+	Gamma float64
+}
+
+// LotkaVolterra is the Lotka-Volterra predator-prey model: prey grow at Alpha and are eaten at
+// Beta per predator, and predators grow at Delta per prey and die at Gamma.
+type LotkaVolterra struct {
+	Alpha float64
+	Beta  float64
+	Delta float64
+	Gamma float64
+}
+
+// reactionDefaults holds the named parameters of every model and their default values.
+var reactionDefaults = map[string]map[string]float64{
+	"gray-scott":      {"f": 0.042, "k": 0.101},
+	"fitzhugh-nagumo": {"epsilon": 0.05, "a0": -0.005, "a1": 2, "gamma": 0.1},
+	"brusselator":     {"a": 4.5, "b": 6.75, "gamma": 0.05},
+	"schnakenberg":    {"a": 0.1, "b": 0.9, "gamma": 0.05},
+	"lotka-volterra":  {"alpha": 0.1, "beta": 0.2, "delta": 0.2, "gamma": 0.05},
+}
+
+// ModelNames lists the names understood by ModelFromName.
+var ModelNames = []string{"gray-scott", "fitzhugh-nagumo", "brusselator", "schnakenberg", "lotka-volterra"}
+
+func (m GrayScott) Change(currentCell Cell, row, col int) Cell {
+	return ChangeDueToReactions(currentCell, m.FeedRate, m.KillRate)
+}
+
+func (m FitzHughNagumo) Change(currentCell Cell, row, col int) Cell {
+	u, v := currentCell[0], currentCell[1]
+	return Cell{m.Gamma * (u - u*u*u - v), m.Gamma * m.Epsilon * (u - m.A1*v - m.A0)}
+}
+
+func (m Brusselator) Change(currentCell Cell, row, col int) Cell {
+	u, v := currentCell[0], currentCell[1]
+	return Cell{m.Gamma * (m.A - (m.B+1)*u + u*u*v), m.Gamma * (m.B*u - u*u*v)}
+}
+
+func (m Schnakenberg) Change(currentCell Cell, row, col int) Cell {
+	u, v := currentCell[0], currentCell[1]
+	return Cell{m.Gamma * (m.A - u + u*u*v), m.Gamma * (m.B - u*u*v)}
+}
+
+func (m LotkaVolterra) Change(currentCell Cell, row, col int) Cell {
+	u, v := currentCell[0], currentCell[1]
+	return Cell{m.Alpha*u - m.Beta*u*v, m.Delta*u*v - m.Gamma*v}
+}
+
+// ReactionParameters takes the name of a model and values for some of its parameters.
+// It returns every parameter of the model, with the default values of those not given.
+func ReactionParameters(name string, values map[string]float64) (map[string]float64, error) {
+
+	defaults, ok := reactionDefaults[name]
+	if !ok {
+		return nil, errors.New("model must be " + strings.Join(ModelNames, ", "))
+	}
+	params := make(map[string]float64, len(defaults))
+	for param, value := range defaults {
+		params[param] = value
+	}
+	for param, value := range values {
+		if _, ok := params[param]; !ok {
+			return nil, fmt.Errorf("%s has no parameter %q, only %s", name, param, strings.Join(sortedKeys(defaults), ", "))
+		}
+		params[param] = value
+	}
+	return params, nil
+}
+
+// ModelFromName takes the name of a model and values for some of its parameters.
+// It returns the model, with the default values of the parameters not given.
+func ModelFromName(name string, values map[string]float64) (ReactionModel, error) {
+
+	p, err := ReactionParameters(name, values)
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "gray-scott":
+		return GrayScott{FeedRate: p["f"], KillRate: p["k"]}, nil
+	case "fitzhugh-nagumo":
+		return FitzHughNagumo{Epsilon: p["epsilon"], A0: p["a0"], A1: p["a1"], Gamma: p["gamma"]}, nil
+	case "brusselator":
+		return Brusselator{A: p["a"], B: p["b"], Gamma: p["gamma"]}, nil
+	case "schnakenberg":
+		return Schnakenberg{A: p["a"], B: p["b"], Gamma: p["gamma"]}, nil
+	default:
+		return LotkaVolterra{Alpha: p["alpha"], Beta: p["beta"], Delta: p["delta"], Gamma: p["gamma"]}, nil
+	}
+}
+
+// ParseReactionParameters takes parameters written as "name=value,name=value".
+// It returns their values by name.
+func ParseReactionParameters(text string) (map[string]float64, error) {
+
+	values := make(map[string]float64)
+	if strings.TrimSpace(text) == "" {
+		return values, nil
+	}
+	for _, part := range strings.Split(text, ",") {
+		fields := strings.Split(part, "=")
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("reaction parameter %q must be name=value", part)
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("reaction parameter %q is not a number", part)
+		}
+		values[strings.TrimSpace(fields[0])] = value
+	}
+	return values, nil
+}
+
+// Input: a map of parameter values.
+// Return: its keys in alphabetical order.
+func sortedKeys(values map[string]float64) []string {
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A board is saved in one of two formats, picked by the extension of the file name.
+//
+// A .csv file starts with the metadata, one "# name=value" comment line each, then has the header
+// row,col,prey,predator and one line for every cell, so pandas.read_csv(name, comment="#") reads it
+// as it is.
+//
+// Any other file is binary. It is a NumPy .npy file of little-endian float64s shaped
+// (rows, columns, 2), with prey at [..., 0] and predators at [..., 1], so numpy.load reads the board
+// straight from it. The metadata follows the array as JSON, then the length of the JSON as a
+// little-endian uint64 and then metadataMagic. numpy.load ignores these trailing bytes, and a plain
+// .npy file without them loads as a board with no metadata.
+
+// npyMagic starts every .npy file, and is followed by the version of the format, 1.0 here.
+const npyMagic = "\x93NUMPY"
+
+// metadataMagic ends the metadata written after the array of a binary board.
+const metadataMagic = "GSMETA01"
+
+// npyShape matches the header of the .npy files that hold a board, and picks out its size.
+var npyShape = regexp.MustCompile(`^\{'descr': '<f8', 'fortran_order': False, 'shape': \((\d+), (\d+), 2\), \}\s*$`)
+
+// SaveBoard takes a file name, a Board and the metadata of the run that made it.
+// It writes the board and metadata to the file, as CSV if the name ends in .csv and in the binary
+// format otherwise.
+func SaveBoard(filename string, b Board, metadata map[string]string) error {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	if isCSV(filename) {
+		err = writeBoardCSV(file, b, metadata)
+	} else {
+		err = writeBoardBinary(file, b, metadata)
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// LoadBoard takes the name of a file written by SaveBoard, or a .npy file of float64s shaped
+// (rows, columns, 2).
+// It returns the board in it and the metadata saved with it.
+func LoadBoard(filename string) (Board, map[string]string, error) {
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	var b Board
+	var metadata map[string]string
+	if isCSV(filename) {
+		b, metadata, err = readBoardCSV(data)
+	} else {
+		b, metadata, err = readBoardBinary(data)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	if err := CheckFinite(b); err != nil {
+		return nil, nil, fmt.Errorf("%s: %v", filename, err)
+	}
+	return b, metadata, nil
+}
+
+// Input: a file name.
+// Return: true if it names a CSV file.
+func isCSV(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".csv")
+}
+
+// Input: somewhere to write, a Board (assumes rectangular) and its metadata.
+// Return: an error if the board can't be written in the binary format.
+func writeBoardBinary(w io.Writer, b Board, metadata map[string]string) error {
+
+	header := fmt.Sprintf("{'descr': '<f8', 'fortran_order': False, 'shape': (%d, %d, 2), }", CountRows(b), CountCols(b))
+	// the header is padded with spaces and ends in a newline, so the array starts on a multiple of 64 bytes
+	total := len(npyMagic) + 4 + len(header) + 1
+	header += strings.Repeat(" ", (64-total%64)%64) + "\n"
+
+	var buffer bytes.Buffer
+	buffer.WriteString(npyMagic)
+	buffer.Write([]byte{1, 0})
+	binary.Write(&buffer, binary.LittleEndian, uint16(len(header)))
+	buffer.WriteString(header)
+	for r := range b {
+		for c := range b[r] {
+			binary.Write(&buffer, binary.LittleEndian, b[r][c])
+		}
+	}
+
+	text, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	buffer.Write(text)
+	binary.Write(&buffer, binary.LittleEndian, uint64(len(text)))
+	buffer.WriteString(metadataMagic)
+
+	_, err = w.Write(buffer.Bytes())
+	return err
+}
+
+// Input: the contents of a binary board file.
+// Return: the board and metadata in it.
+func readBoardBinary(data []byte) (Board, map[string]string, error) {
+
+	if len(data) < 10 || string(data[:6]) != npyMagic || data[6] != 1 {
+		return nil, nil, errors.New("not a version 1 .npy file")
+	}
+	headerEnd := 10 + int(binary.LittleEndian.Uint16(data[8:10]))
+	if headerEnd > len(data) {
+		return nil, nil, errors.New("the .npy header is cut short")
+	}
+	match := npyShape.FindStringSubmatch(string(data[10:headerEnd]))
+	if match == nil {
+		return nil, nil, errors.New("the array must hold little-endian float64s in C order, shaped (rows, columns, 2)")
+	}
+	numRows, err1 := strconv.Atoi(match[1])
+	numCols, err2 := strconv.Atoi(match[2])
+	if err1 != nil || err2 != nil || numRows == 0 || numCols == 0 || numRows > len(data) || numCols > len(data) ||
+		numRows*numCols > (len(data)-headerEnd)/16 {
+		return nil, nil, errors.New("the array is empty or larger than the file")
+	}
+
+	b := InitializeBoard(numRows, numCols)
+	values := data[headerEnd:]
+	for r := range b {
+		for c := range b[r] {
+			for species := range b[r][c] {
+				b[r][c][species] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+				values = values[8:]
+			}
+		}
+	}
+
+	// whatever follows the array is the metadata, if there is any
+	metadata := make(map[string]string)
+	if len(values) == 0 {
+		return b, metadata, nil
+	}
+	trailer := len(values) - 8 - len(metadataMagic)
+	if trailer < 0 || string(values[trailer+8:]) != metadataMagic {
+		return nil, nil, errors.New("unknown data after the array")
+	}
+	if length := binary.LittleEndian.Uint64(values[trailer:]); length != uint64(trailer) {
+		return nil, nil, errors.New("the metadata is cut short")
+	}
+	if err := json.Unmarshal(values[:trailer], &metadata); err != nil {
+		return nil, nil, fmt.Errorf("metadata: %v", err)
+	}
+	return b, metadata, nil
+}
+
+// Input: somewhere to write, a Board and its metadata.
+// Return: an error if the board can't be written as CSV.
+func writeBoardCSV(w io.Writer, b Board, metadata map[string]string) error {
+
+	var buffer bytes.Buffer
+	for _, name := range sortedNames(metadata) {
+		if strings.ContainsAny(name+metadata[name], "\r\n") || strings.Contains(name, "=") {
+			return fmt.Errorf("metadata %q can't be written to a CSV comment", name)
+		}
+		fmt.Fprintf(&buffer, "# %s=%s\n", name, metadata[name])
+	}
+
+	records := csv.NewWriter(&buffer)
+	records.Write([]string{"row", "col", "prey", "predator"})
+	for r := range b {
+		for c := range b[r] {
+			// the shortest text that reads back as the same float64, so nothing is lost
+			records.Write([]string{
+				strconv.Itoa(r),
+				strconv.Itoa(c),
+				strconv.FormatFloat(b[r][c][0], 'g', -1, 64),
+				strconv.FormatFloat(b[r][c][1], 'g', -1, 64),
+			})
+		}
+	}
+	records.Flush()
+	if err := records.Error(); err != nil {
+		return err
+	}
+
+	_, err := w.Write(buffer.Bytes())
+	return err
+}
+
+// Input: the contents of a CSV board file.
+// Return: the board and metadata in it. Every cell of the board must appear exactly once.
+func readBoardCSV(data []byte) (Board, map[string]string, error) {
+
+	metadata := make(map[string]string)
+	text := string(data)
+	for strings.HasPrefix(text, "#") {
+		line := text
+		if end := strings.IndexByte(text, '\n'); end >= 0 {
+			line, text = text[:end], text[end+1:]
+		} else {
+			text = ""
+		}
+		parts := strings.SplitN(strings.TrimSpace(strings.TrimPrefix(line, "#")), "=", 2)
+		if len(parts) == 2 {
+			metadata[parts[0]] = parts[1]
+		}
+	}
+
+	records, err := csv.NewReader(strings.NewReader(text)).ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(records) < 2 || strings.Join(records[0], ",") != "row,col,prey,predator" {
+		return nil, nil, errors.New("must have the header row,col,prey,predator and at least one cell")
+	}
+	records = records[1:]
+
+	// the size of the board is read off the largest row and column
+	cells := make([][4]float64, len(records))
+	numRows, numCols := 0, 0
+	for i, record := range records {
+		for j, field := range record {
+			cells[i][j], err = strconv.ParseFloat(strings.TrimSpace(field), 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("line %d: %q is not a number", i+2, field)
+			}
+		}
+		r, c := int(cells[i][0]), int(cells[i][1])
+		if r < 0 || c < 0 || float64(r) != cells[i][0] || float64(c) != cells[i][1] {
+			return nil, nil, fmt.Errorf("line %d: row and col must be whole numbers that are not negative", i+2)
+		}
+		if r >= numRows {
+			numRows = r + 1
+		}
+		if c >= numCols {
+			numCols = c + 1
+		}
+	}
+	if numRows*numCols != len(cells) {
+		return nil, nil, fmt.Errorf("has %d cells, but its rows and columns span %d x %d", len(cells), numRows, numCols)
+	}
+
+	b := InitializeBoard(numRows, numCols)
+	seen := make([]bool, numRows*numCols)
+	for i, cell := range cells {
+		r, c := int(cell[0]), int(cell[1])
+		if seen[r*numCols+c] {
+			return nil, nil, fmt.Errorf("line %d: row %d, column %d appears twice", i+2, r, c)
+		}
+		seen[r*numCols+c] = true
+		b[r][c] = Cell{cell[2], cell[3]}
+	}
+	return b, metadata, nil
+}
+
+// Input: a map of metadata.
+// Return: its names in alphabetical order.
+func sortedNames(metadata map[string]string) []string {
+
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BoardFromImage takes an image, the size of the board, an amount of noise and a random number generator.
+// It returns a Board full of prey with predators wherever the image is dark, so a pattern can be
+// seeded from a drawing: black pixels put a predator concentration of 1 in their cell and white
+// pixels none. The image is stretched over the board as LoadField does, and noise is added as in InitialBoard.
+func BoardFromImage(filename string, numRows, numCols int, noise float64, rng *rand.Rand) (Board, error) {
+
+	predators, err := loadFieldImage(filename, numRows, numCols, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	b := InitializeBoard(numRows, numCols)
+	for r := range b {
+		for c := range b[r] {
+			b[r][c] = Cell{1, predators[r][c]}
+			if noise > 0 {
+				b[r][c][1] += noise * rng.Float64()
+			}
+		}
+	}
+	return b, nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+)
+
+// Avalanche records the relaxation of a board after a single grain is dropped on it.
+type Avalanche struct {
+	Row, Col int     // where the grain was dropped
+	Size     int     // total number of topples
+	Area     int     // number of distinct cells that toppled
+	Duration int     // number of sweeps until the board was stable again
+	Extent   float64 // largest distance from the drop site to a cell that toppled
+}
+
+// SiteChooser returns the row and column the next grain is dropped on.
+type SiteChooser func() (int, int)
+
+// RandomSites takes a random number generator and the size of a board.
+// It returns a SiteChooser picking a uniformly random cell of the board for every grain.
+func RandomSites(rng *rand.Rand, numRows, numCols int) SiteChooser {
+	return func() (int, int) {
+		return rng.Intn(numRows), rng.Intn(numCols)
+	}
+}
+
+// FixedSite takes a row and column.
+// It returns a SiteChooser that drops every grain on that cell.
+func FixedSite(row, col int) SiteChooser {
+	return func() (int, int) {
+		return row, col
+	}
+}
+
+// DriveSandpile takes a stable board, the lattice it topples on, a number of grains and a SiteChooser.
+// It drops the grains one at a time, letting the board relax fully after each one, and returns
+// the avalanche caused by every grain in order. The board is modified in place.
+// It returns an error if the lattice is closed: no grain ever leaves it, so the board soon holds
+// too many to relax at all.
+func DriveSandpile(currentBoard Board, lattice Lattice, numGrains int, chooseSite SiteChooser) ([]Avalanche, error) {
+
+	if Closed(lattice) {
+		return nil, errors.New("a sandpile can only be driven on a lattice that loses grains, not a closed one")
+	}
+	avalanches := make([]Avalanche, numGrains)
+	for i := range avalanches {
+		row, col := chooseSite()
+		currentBoard[row][col]++
+		avalanches[i] = relax(currentBoard, lattice, row, col)
+	}
+	return avalanches, nil
+}
+
+// Input: a board that is stable everywhere except possibly at (row, col), and its lattice, which must
+// not be closed
+// Output: the board relaxed in place and the avalanche it took. Every sweep topples once each cell
+// that was unstable when the sweep began, so only the cells near the last sweep's topples are rechecked
+func relax(b Board, lattice Lattice, row, col int) Avalanche {
+
+	avalanche := Avalanche{Row: row, Col: col}
+	threshold := lattice.Threshold()
+	numRows := len(b)
+	numCols := len(b[0])
+	toppled := make(map[[2]int]bool)
+
+	unstable := make([][2]int, 0)
+	if b[row][col] >= threshold {
+		unstable = append(unstable, [2]int{row, col})
+	}
+
+	for len(unstable) > 0 {
+		avalanche.Duration++
+		for _, cell := range unstable {
+			b.ToppleOn(lattice, cell[0], cell[1])
+			avalanche.Size++
+			toppled[cell] = true
+			distance := math.Hypot(float64(cell[0]-row), float64(cell[1]-col))
+			avalanche.Extent = math.Max(avalanche.Extent, distance)
+		}
+
+		// Only the cells that toppled and their neighbours can have become unstable
+		seen := make(map[[2]int]bool)
+		next := make([][2]int, 0)
+		for _, cell := range unstable {
+			candidates := [][2]int{cell}
+			for _, offset := range lattice.Neighbours(cell[0], cell[1]) {
+				r, c, ok := lattice.Boundary(cell[0]+offset[0], cell[1]+offset[1], numRows, numCols)
+				if ok {
+					candidates = append(candidates, [2]int{r, c})
+				}
+			}
+			for _, candidate := range candidates {
+				if !seen[candidate] && b[candidate[0]][candidate[1]] >= threshold {
+					seen[candidate] = true
+					next = append(next, candidate)
+				}
+			}
+		}
+		unstable = next
+	}
+	avalanche.Area = len(toppled)
+	return avalanche
+}
+
+// HistogramBin is one logarithmically sized bin of an avalanche histogram, holding the values in [Lower, Upper).
+type HistogramBin struct {
+	Lower, Upper int
+	Count        int
+	Density      float64 // Count divided by the bin width and the number of values
+}
+
+// LogHistogram takes a list of values and a growth factor greater than 1.
+// It returns the values binned into bins starting at 1 whose widths grow by the factor each time,
+// which keeps the tail of a power-law distribution from being lost in empty bins.
+// Values below 1 (grains that caused no topples) are left out.
+func LogHistogram(values []int, factor float64) []HistogramBin {
+
+	if factor <= 1 {
+		panic("Error: LogHistogram needs a growth factor greater than 1.")
+	}
+
+	maxValue := 0
+	numValues := 0
+	for _, val := range values {
+		if val >= 1 {
+			numValues++
+			if val > maxValue {
+				maxValue = val
+			}
+		}
+	}
+
+	bins := make([]HistogramBin, 0)
+	for lower := 1; lower <= maxValue; {
+		// Every bin holds at least one integer so the first few bins never come out empty
+		upper := int(math.Ceil(float64(lower) * factor))
+		if upper <= lower {
+			upper = lower + 1
+		}
+		bins = append(bins, HistogramBin{Lower: lower, Upper: upper})
+		lower = upper
+	}
+
+	for _, val := range values {
+		for i := range bins {
+			if val >= bins[i].Lower && val < bins[i].Upper {
+				bins[i].Count++
+				break
+			}
+		}
+	}
+
+	for i := range bins {
+		bins[i].Density = float64(bins[i].Count) / float64((bins[i].Upper-bins[i].Lower)*numValues)
+	}
+	return bins
+}
+
+// PowerLawExponent takes a log-binned histogram.
+// It returns the exponent tau of the power law P(x) ~ x^-tau fitted by least squares to log(density)
+// against the log of each bin's geometric centre, using only the bins that are not empty.
+// It returns NaN if fewer than two bins hold values.
+func PowerLawExponent(bins []HistogramBin) float64 {
+
+	xs := make([]float64, 0)
+	ys := make([]float64, 0)
+	for _, bin := range bins {
+		if bin.Count > 0 {
+			xs = append(xs, math.Log(math.Sqrt(float64(bin.Lower*(bin.Upper-1)))))
+			ys = append(ys, math.Log(bin.Density))
+		}
+	}
+	if len(xs) < 2 {
+		return math.NaN()
+	}
+
+	var meanX, meanY float64
+	for i := range xs {
+		meanX += xs[i]
+		meanY += ys[i]
+	}
+	meanX /= float64(len(xs))
+	meanY /= float64(len(ys))
+
+	var covariance, variance float64
+	for i := range xs {
+		covariance += (xs[i] - meanX) * (ys[i] - meanY)
+		variance += (xs[i] - meanX) * (xs[i] - meanX)
+	}
+	return -covariance / variance
+}
+
+// WriteAvalanches writes one row per avalanche with its drop site, size, area, duration and extent to a CSV file.
+func WriteAvalanches(avalanches []Avalanche, filename string) error {
+
+	outFile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	writer := csv.NewWriter(outFile)
+	if err := writer.Write([]string{"row", "col", "size", "area", "duration", "extent"}); err != nil {
+		return err
+	}
+	for _, a := range avalanches {
+		row := []string{
+			strconv.Itoa(a.Row),
+			strconv.Itoa(a.Col),
+			strconv.Itoa(a.Size),
+			strconv.Itoa(a.Area),
+			strconv.Itoa(a.Duration),
+			strconv.FormatFloat(a.Extent, 'f', 4, 64),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// WriteAvalancheHistograms writes the log-binned size and duration histograms of the avalanches to a CSV file.
+// Every row also holds the power-law exponent fitted to the histogram it belongs to.
+func WriteAvalancheHistograms(avalanches []Avalanche, factor float64, filename string) error {
+
+	sizes := make([]int, len(avalanches))
+	durations := make([]int, len(avalanches))
+	for i, a := range avalanches {
+		sizes[i] = a.Size
+		durations[i] = a.Duration
+	}
+
+	outFile, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer outFile.Close()
+
+	writer := csv.NewWriter(outFile)
+	if err := writer.Write([]string{"quantity", "bin_lower", "bin_upper", "count", "density", "exponent"}); err != nil {
+		return err
+	}
+
+	histograms := []struct {
+		name   string
+		values []int
+	}{
+		{"size", sizes},
+		{"duration", durations},
+	}
+	for _, histogram := range histograms {
+		bins := LogHistogram(histogram.values, factor)
+		exponent := strconv.FormatFloat(PowerLawExponent(bins), 'f', 4, 64)
+		for _, bin := range bins {
+			row := []string{
+				histogram.name,
+				strconv.Itoa(bin.Lower),
+				strconv.Itoa(bin.Upper),
+				strconv.Itoa(bin.Count),
+				strconv.FormatFloat(bin.Density, 'g', 6, 64),
+				exponent,
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BenchConfig describes a sweep of parameter space: every engine is run on every combination of
+// board size and coin count, and every parallel engine with every worker count as well.
+type BenchConfig struct {
+	Sizes   []int // widths of the square boards
+	Coins   []int
+	Workers []int // worker counts for the parallel engines; the other engines always use one
+	Engines []string
+	Trials  int
+	Lattice Lattice
+	Init    string        // an initial configuration understood by NewInitialBoard
+	Options ConfigOptions // options of the initial configuration; NumCoins and Rng are set for every board
+	Seed    int64         // seed of the Rng given to every board, so each one can be made again
+}
+
+// BenchResult holds the timings of one combination of a parameter sweep.
+type BenchResult struct {
+	Engine      string  `json:"engine"`
+	Size        int     `json:"size"`
+	Coins       int     `json:"coins"`
+	Workers     int     `json:"workers"`
+	Trials      int     `json:"trials"`
+	MeanSeconds float64 `json:"mean_seconds"`
+	MinSeconds  float64 `json:"min_seconds"`
+	Speedup     float64 `json:"speedup"`     // mean time of the serial engine on the same board over this one's; 0 if serial was not run
+	Sweeps      int     `json:"sweeps"`      // sweeps to stability; 0 for the sparse engine, which does not sweep
+	GrainsLost  int     `json:"grains_lost"` // coins that fell off the edge of the board
+}
+
+// RunBench takes a parameter sweep and a function called with every result as soon as it is ready,
+// which may be nil.
+// It returns the results of every combination in the order they were run. Each trial starts from
+// the same initial board and is timed without snapshots. The sweeps and grains lost come from one
+// more untimed run that counts every sweep.
+func RunBench(config BenchConfig, progress func(BenchResult)) ([]BenchResult, error) {
+
+	if config.Trials <= 0 {
+		return nil, errors.New("bench needs a positive number of trials")
+	}
+
+	results := make([]BenchResult, 0)
+	for _, size := range config.Sizes {
+		for _, numCoins := range config.Coins {
+			opts := config.Options
+			opts.NumCoins = numCoins
+			opts.Rng = rand.New(rand.NewSource(config.Seed))
+			board, err := NewInitialBoard(config.Init, size, size, opts)
+			if err != nil {
+				return nil, err
+			}
+			if err := CheckCapacity(board, config.Lattice, config.Lattice.Threshold()); err != nil {
+				return nil, err
+			}
+
+			// results of this board only, so speedups are taken against the serial run on the same board
+			boardResults := make([]BenchResult, 0)
+			for _, engine := range config.Engines {
+				workers := config.Workers
+				if engine != "parallel" && engine != "checkerboard-parallel" {
+					workers = []int{1}
+				}
+				for _, numProcs := range workers {
+					result, err := benchEngine(engine, board, numProcs, config.Lattice, config.Trials)
+					if err != nil {
+						return nil, err
+					}
+					result.Size = size
+					result.Coins = numCoins
+					boardResults = append(boardResults, result)
+					if progress != nil {
+						progress(result)
+					}
+				}
+			}
+
+			setSpeedups(boardResults)
+			results = append(results, boardResults...)
+		}
+	}
+	return results, nil
+}
+
+// Input: the name of an engine, the initial board, the number of workers, the lattice and the number of trials
+// Output: the timings of the engine on copies of the board, with the sweeps to stability and grains lost
+func benchEngine(engine string, board Board, numProcs int, lattice Lattice, trials int) (BenchResult, error) {
+
+	result := BenchResult{Engine: engine, Workers: numProcs, Trials: trials, MinSeconds: math.Inf(1)}
+
+	total := 0.0
+	for i := 0; i < trials; i++ {
+		trialBoard := copyBoard(board)
+		start := time.Now()
+		if err := simulateEngine(engine, trialBoard, numProcs, lattice, math.MaxInt, discardSink{}, nil); err != nil {
+			return result, err
+		}
+		seconds := time.Since(start).Seconds()
+		total += seconds
+		result.MinSeconds = math.Min(result.MinSeconds, seconds)
+	}
+	result.MeanSeconds = total / float64(trials)
+
+	// A snapshot after every sweep counts them: the sink also sees the input and final boards
+	counter := &frameCounter{}
+	finalBoard := copyBoard(board)
+	if err := simulateEngine(engine, finalBoard, numProcs, lattice, 1, counter, nil); err != nil {
+		return result, err
+	}
+	if counter.frames > 2 {
+		result.Sweeps = counter.frames - 2
+	}
+	result.GrainsLost = boardTotal(board) - boardTotal(finalBoard)
+	return result, nil
+}
+
+// Input: the results of every engine on the same board
+// Output: the results with their speedup over the serial engine set, if serial is among them
+func setSpeedups(results []BenchResult) {
+
+	serialSeconds := 0.0
+	for _, result := range results {
+		if result.Engine == "serial" {
+			serialSeconds = result.MeanSeconds
+		}
+	}
+	if serialSeconds == 0 {
+		return
+	}
+	for i := range results {
+		if results[i].MeanSeconds > 0 {
+			results[i].Speedup = serialSeconds / results[i].MeanSeconds
+		}
+	}
+}
+
+// Input: a board
+// Output: the total number of coins on the board
+func boardTotal(b Board) int {
+
+	total := 0
+	for r := range b {
+		for c := range b[r] {
+			total += b[r][c]
+		}
+	}
+	return total
+}
+
+// discardSink is a FrameSink that throws every frame away, so timings only measure the engine.
+type discardSink struct{}
+
+func (discardSink) AddFrame(b Board) error { return nil }
+
+func (discardSink) Close() error { return nil }
+
+// frameCounter is a FrameSink that only counts the frames it is given.
+type frameCounter struct {
+	frames int
+}
+
+func (f *frameCounter) AddFrame(b Board) error {
+	f.frames++
+	return nil
+}
+
+func (f *frameCounter) Close() error { return nil }
+
+// ParseIntRange takes a comma separated list whose items are single numbers or ranges written
+// start:end:step, which count up by step, or start:end:xfactor, which multiply by factor. Both
+// kinds of range include end if they reach it, and a range without a step counts up by one.
+// It returns every number in order, for example 1000:100000:x10,3 gives 1000, 10000, 100000, 3,
+// or an error if the list is malformed or gives more than maxRangeValues numbers.
+func ParseIntRange(text string) ([]int, error) {
+
+	values := make([]int, 0)
+	for _, part := range strings.Split(text, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ":")
+		if len(fields) == 1 {
+			val, err := strconv.Atoi(fields[0])
+			if err != nil {
+				return nil, fmt.Errorf("%q is not a number", part)
+			}
+			if len(values) == maxRangeValues {
+				return nil, fmt.Errorf("%q gives more than %d numbers", text, maxRangeValues)
+			}
+			values = append(values, val)
+			continue
+		}
+		if len(fields) > 3 {
+			return nil, fmt.Errorf("range %q must be start:end or start:end:step", part)
+		}
+
+		start, err1 := strconv.Atoi(fields[0])
+		end, err2 := strconv.Atoi(fields[1])
+		if err1 != nil || err2 != nil || end < start {
+			return nil, fmt.Errorf("range %q must have a start no larger than its end", part)
+		}
+		step, multiply := 1, false
+		if len(fields) == 3 {
+			multiply = strings.HasPrefix(fields[2], "x")
+			var err error
+			step, err = strconv.Atoi(strings.TrimPrefix(fields[2], "x"))
+			if err != nil || step < 1 || (multiply && (step < 2 || start < 1)) {
+				return nil, fmt.Errorf("range %q needs a positive step, or a factor of at least 2 and a positive start", part)
+			}
+		}
+
+		// the next value is checked against end before it is taken, so a range that ends near the
+		// largest int stops instead of overflowing
+		for val := start; ; {
+			if len(values) == maxRangeValues {
+				return nil, fmt.Errorf("%q gives more than %d numbers", text, maxRangeValues)
+			}
+			values = append(values, val)
+			if multiply {
+				if val > end/step {
+					break
+				}
+				val *= step
+			} else {
+				if val+step < val || val+step > end {
+					break
+				}
+				val += step
+			}
+		}
+	}
+	return values, nil
+}
+
+// maxRangeValues is the most numbers ParseIntRange gives, far more than any sweep can run.
+const maxRangeValues = 10000
+
+// WriteBenchReport writes the results of a parameter sweep to a file, as CSV with a header row
+// if its name ends in .csv or as a JSON array if it ends in .json.
+func WriteBenchReport(results []BenchResult, filename string) error {
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return writeBenchCSV(results, filename)
+	case ".json":
+		return writeBenchJSON(results, filename)
+	}
+	return errors.New("bench report " + filename + " must end in .csv or .json")
+}
+
+// writeBenchCSV writes one row per result to a CSV file.
+func writeBenchCSV(results []BenchResult, filename string) error {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	header := []string{"engine", "size", "coins", "workers", "trials", "mean_seconds", "min_seconds", "speedup", "sweeps", "grains_lost"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, r := range results {
+		row := []string{
+			r.Engine,
+			strconv.Itoa(r.Size),
+			strconv.Itoa(r.Coins),
+			strconv.Itoa(r.Workers),
+			strconv.Itoa(r.Trials),
+			strconv.FormatFloat(r.MeanSeconds, 'f', 6, 64),
+			strconv.FormatFloat(r.MinSeconds, 'f', 6, 64),
+			strconv.FormatFloat(r.Speedup, 'f', 3, 64),
+			strconv.Itoa(r.Sweeps),
+			strconv.Itoa(r.GrainsLost),
+		}
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeBenchJSON writes the results to a file as an indented JSON array.
+func writeBenchJSON(results []BenchResult, filename string) error {
+
+	data, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, append(data, '\n'), 0644)
+}
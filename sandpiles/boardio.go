@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ReadBoard takes the name of a board file and returns the board stored in it.
+// The format follows the extension: .csv holds one comma separated row per line, .txt holds
+// whitespace separated rows, and .png is a grayscale image whose pixel values are the coin counts.
+func ReadBoard(filename string) (Board, error) {
+
+	var board Board
+	var err error
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		board, err = readBoardCSV(filename)
+	case ".txt":
+		board, err = readBoardText(filename)
+	case ".png":
+		board, err = readBoardPNG(filename)
+	default:
+		return nil, errors.New("board file " + filename + " must end in .csv, .txt or .png")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(board) == 0 || len(board[0]) == 0 {
+		return nil, errors.New("board file " + filename + " is empty")
+	}
+	for i := range board {
+		if len(board[i]) != len(board[0]) {
+			return nil, fmt.Errorf("%s row %d has %d cells, want %d", filename, i+1, len(board[i]), len(board[0]))
+		}
+	}
+	return board, nil
+}
+
+// WriteBoard takes a board and the name of the file to write it to, in the format given by the
+// extension as described for ReadBoard. A PNG uses 8-bit gray unless a cell holds more than 255
+// coins, in which case it uses 16-bit gray, so every board up to 65535 coins per cell round-trips exactly.
+func WriteBoard(b Board, filename string) error {
+
+	for r := range b {
+		for c := range b[r] {
+			if b[r][c] < 0 {
+				return errors.New("can't write a board holding a negative number of coins")
+			}
+		}
+	}
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		return writeBoardCSV(b, filename)
+	case ".txt":
+		return writeBoardText(b, filename)
+	case ".png":
+		return writeBoardPNG(b, filename)
+	}
+	return errors.New("board file " + filename + " must end in .csv, .txt or .png")
+}
+
+// Input: the name of a CSV file holding one row of the board per line
+// Output: the board, which must hold no negative values
+func readBoardCSV(filename string) (Board, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	board := make(Board, len(records))
+	for i, record := range records {
+		if board[i], err = parseBoardRow(record); err != nil {
+			return nil, fmt.Errorf("%s row %d: %w", filename, i+1, err)
+		}
+	}
+	return board, nil
+}
+
+// Input: the name of a text file holding one row of the board per line, with cells separated by
+// whitespace; blank lines are skipped
+// Output: the board, which must hold no negative values
+func readBoardText(filename string) (Board, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	board := make(Board, 0)
+	scanner := bufio.NewScanner(file)
+	// Rows of very wide boards are longer than the scanner's default limit
+	scanner.Buffer(make([]byte, 0, 64*1024), math.MaxInt32)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		row, err := parseBoardRow(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s row %d: %w", filename, len(board)+1, err)
+		}
+		board = append(board, row)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return board, nil
+}
+
+// Input: the name of a grayscale PNG
+// Output: the board whose cells are the gray values of the pixels
+func readBoardPNG(filename string) (Board, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, err := png.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	board := make(Board, bounds.Dy())
+	for r := range board {
+		board[r] = make([]int, bounds.Dx())
+		for c := range board[r] {
+			switch pixel := img.At(bounds.Min.X+c, bounds.Min.Y+r).(type) {
+			case color.Gray:
+				board[r][c] = int(pixel.Y)
+			case color.Gray16:
+				board[r][c] = int(pixel.Y)
+			default:
+				return nil, errors.New("board image " + filename + " must be a grayscale PNG")
+			}
+		}
+	}
+	return board, nil
+}
+
+// Input: a list of fields holding one row of a board
+// Output: the row as coin counts, or an error if a field is not a non-negative integer
+func parseBoardRow(fields []string) ([]int, error) {
+
+	row := make([]int, len(fields))
+	for j, field := range fields {
+		val, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if val < 0 {
+			return nil, errors.New("negative number of coins")
+		}
+		row[j] = val
+	}
+	return row, nil
+}
+
+// writeBoardCSV writes every row of the board to a CSV file.
+func writeBoardCSV(b Board, filename string) error {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := csv.NewWriter(file)
+	for _, row := range b {
+		record := make([]string, len(row))
+		for j, val := range row {
+			record[j] = strconv.Itoa(val)
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeBoardText writes every row of the board to a text file with cells separated by spaces.
+func writeBoardText(b Board, filename string) error {
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	for _, row := range b {
+		for j, val := range row {
+			if j > 0 {
+				writer.WriteByte(' ')
+			}
+			writer.WriteString(strconv.Itoa(val))
+		}
+		writer.WriteByte('\n')
+	}
+	return writer.Flush()
+}
+
+// writeBoardPNG writes the board to a grayscale PNG with one pixel per cell.
+func writeBoardPNG(b Board, filename string) error {
+
+	if len(b) == 0 || len(b[0]) == 0 {
+		return errors.New("can't write an empty board to " + filename)
+	}
+
+	maxValue := 0
+	for r := range b {
+		for c := range b[r] {
+			if b[r][c] > maxValue {
+				maxValue = b[r][c]
+			}
+		}
+	}
+	if maxValue > math.MaxUint16 {
+		return fmt.Errorf("can't write a cell holding %d coins to a PNG", maxValue)
+	}
+
+	bounds := image.Rect(0, 0, len(b[0]), len(b))
+	var img image.Image
+	if maxValue <= math.MaxUint8 {
+		gray := image.NewGray(bounds)
+		for r := range b {
+			for c := range b[r] {
+				gray.SetGray(c, r, color.Gray{Y: uint8(b[r][c])})
+			}
+		}
+		img = gray
+	} else {
+		gray := image.NewGray16(bounds)
+		for r := range b {
+			for c := range b[r] {
+				gray.SetGray16(c, r, color.Gray16{Y: uint16(b[r][c])})
+			}
+		}
+		img = gray
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return png.Encode(file, img)
+}
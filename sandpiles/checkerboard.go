@@ -0,0 +1,174 @@
+package main
+
+// The checkerboard engines split every sweep into phases, one per colour class of the lattice.
+// No two cells of the same class are neighbours, so during a phase the toppling cells never
+// change each other's coins and the order they topple in makes no difference. Every sweep,
+// and so every snapshot, is therefore the same whichever engine runs it and however many
+// processors share the work. On the von Neumann lattice the classes are the red and black
+// squares of a checkerboard.
+
+// SimulateSandpilesCheckerboard takes as input a Board object, the lattice it topples on, the number
+// of sweeps between snapshots, a FrameSink and an odometer board, which may be nil.
+// It is the serial reference for SimulateSandpilesCheckerboardParallel: each sweep topples the
+// unstable cells of one colour class after another, and the run ends with the first sweep that
+// topples nothing. The input board, every snapshotEvery-th sweep and the final stable board are
+// passed to the sink as it goes, and every topple is counted in the odometer if there is one.
+func SimulateSandpilesCheckerboard(currentBoard Board, lattice Lattice, snapshotEvery int, sink FrameSink, odometer Board) error {
+
+	if snapshotEvery <= 0 {
+		return errSnapshotEvery
+	}
+	if err := sink.AddFrame(currentBoard); err != nil {
+		return err
+	}
+	classes, numClasses := colorClasses(lattice, len(currentBoard), len(currentBoard[0]))
+	threshold := lattice.Threshold()
+	odometer, start := closedOdometer(currentBoard, lattice, odometer)
+	interval := 0
+
+	for {
+		stable := true
+		for phase := 0; phase < numClasses; phase++ {
+			for r := range currentBoard {
+				for c := range currentBoard[r] {
+					if classes[r][c] == phase && currentBoard[r][c] >= threshold {
+						currentBoard.ToppleOn(lattice, r, c)
+						if odometer != nil {
+							odometer[r][c]++
+						}
+						stable = false
+					}
+				}
+			}
+		}
+		interval++
+		if interval%snapshotEvery == 0 {
+			if err := sink.AddFrame(currentBoard); err != nil {
+				return err
+			}
+		}
+		if stable {
+			break
+		}
+		if err := checkNeverStable(odometer, start); err != nil {
+			return err
+		}
+	}
+	return sink.AddFrame(currentBoard)
+}
+
+// SimulateSandpilesCheckerboardParallel takes as input a Board object, the number of processors, the
+// lattice it topples on, the number of sweeps between snapshots, a FrameSink and an odometer board,
+// which may be nil.
+// It runs the same sweeps as SimulateSandpilesCheckerboard, splitting the board into bands as
+// SimulateSandpilesParallel does, and passes the sink exactly the same frames. Every processor
+// topples the cells of the current colour class in its band, and the ghost rows are exchanged
+// between phases so the next class sees every coin sent to it.
+func SimulateSandpilesCheckerboardParallel(currentBoard Board, numProcs int, lattice Lattice, snapshotEvery int, sink FrameSink, odometer Board) error {
+
+	if snapshotEvery <= 0 {
+		return errSnapshotEvery
+	}
+	if err := sink.AddFrame(currentBoard); err != nil {
+		return err
+	}
+	classes, numClasses := colorClasses(lattice, len(currentBoard), len(currentBoard[0]))
+	odometer, start := closedOdometer(currentBoard, lattice, odometer)
+	bands := splitBands(currentBoard, numProcs, odometer)
+	toppled := make(chan bool, len(bands))
+	interval := 0
+
+	for {
+		stable := true
+		for phase := 0; phase < numClasses; phase++ {
+			for _, b := range bands {
+				go toppleClassChunk(lattice, b, classes, phase, len(currentBoard), toppled)
+			}
+			// Waiting on every processor acts as the barrier between phases
+			for range bands {
+				if <-toppled {
+					stable = false
+				}
+			}
+			exchangeGhostRows(bands)
+		}
+		interval++
+
+		if interval%snapshotEvery == 0 {
+			joinBands(currentBoard, bands)
+			if err := sink.AddFrame(currentBoard); err != nil {
+				return err
+			}
+		}
+		if stable {
+			break
+		}
+		if err := checkNeverStable(odometer, start); err != nil {
+			return err
+		}
+	}
+	joinBands(currentBoard, bands)
+	return sink.AddFrame(currentBoard)
+}
+
+// Input: a lattice, a band, the colour class of every cell of the board, the class toppling in this
+// phase, the number of rows in the full board and a channel to report on
+// Output: the band after every unstable cell of the class in its real rows has toppled once, with
+// true sent on the channel if any cell toppled
+func toppleClassChunk(lattice Lattice, b band, classes [][]int, phase, numRows int, toppled chan bool) {
+
+	threshold := lattice.Threshold()
+	didTopple := false
+
+	for row := b.start; row < b.end; row++ {
+		cells := b.cells[row-b.start+1]
+		for col := range cells {
+			if classes[row][col] == phase && cells[col] >= threshold {
+				toppleInBand(lattice, b, numRows, row, col)
+				didTopple = true
+			}
+		}
+	}
+	toppled <- didTopple
+}
+
+// Input: a lattice and the size of the board
+// Output: a colour class for every cell such that no cell sends coins to a cell of its own class,
+// and the number of classes. Cells are coloured greedily in row order with the smallest class none
+// of their neighbours has, which gives the two checkerboard classes on the von Neumann lattice and
+// four on the Moore lattice. The hexagonal lattice and a torus with an odd side need more.
+// Every lattice here is symmetric, so checking the neighbours a cell sends to is enough.
+func colorClasses(lattice Lattice, numRows, numCols int) ([][]int, int) {
+
+	classes := make([][]int, numRows)
+	for r := range classes {
+		classes[r] = make([]int, numCols)
+		for c := range classes[r] {
+			classes[r][c] = -1
+		}
+	}
+
+	numClasses := 0
+	for r := range classes {
+		for c := range classes[r] {
+			taken := make(map[int]bool)
+			for _, offset := range lattice.Neighbours(r, c) {
+				nr, nc, ok := lattice.Boundary(r+offset[0], c+offset[1], numRows, numCols)
+				// a cell on a thin torus may send coins to itself, which never clashes
+				if ok && (nr != r || nc != c) {
+					taken[classes[nr][nc]] = true
+				}
+			}
+
+			class := 0
+			for taken[class] {
+				class++
+			}
+			classes[r][c] = class
+			if class+1 > numClasses {
+				numClasses = class + 1
+			}
+		}
+	}
+	return classes, numClasses
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/gob"
+	"errors"
+	"os"
+)
+
+// Checkpoint holds everything needed to carry on an interrupted run: the board at the time of
+// the checkpoint and the settings the run was started with.
+type Checkpoint struct {
+	Engine        string // serial, parallel, sparse, checkerboard-serial or checkerboard-parallel
+	NumProcs      int
+	SnapshotEvery int
+	Lattice       string // a name understood by LatticeFromName
+	Rule          string // a name understood by RuleFromName
+	RuleSeed      int64  // seed of the rule's random numbers, if it uses any
+	Rotors        Board  // rotor positions of a rotor-router run, nil for the other rules
+	Mask          Mask   // inactive cells of the domain, nil for the full board
+	Format        string // output format understood by NewFrameSink
+	Output        string // base name of the output files
+	CellWidth     int
+	Palette       string // a name understood by PaletteFromName
+	Shape         string // circle or square
+	Legend        bool
+	FrameCounter  bool
+	FramesWritten int // frames already passed to the output, including the board below
+	Board         Board
+	Odometer      Board // topples of every cell up to the board above, nil if they are not counted
+}
+
+// CheckpointSink is a FrameSink that saves a checkpoint of the run after every Every frames.
+// It should come after the output sinks in a MultiSink so a checkpoint never holds a frame the
+// output has not seen yet. Once the run finishes, Close removes the checkpoint file.
+type CheckpointSink struct {
+	Filename string
+	Every    int
+	Run      Checkpoint // settings of the run; FramesWritten counts the frames of earlier runs
+	numSeen  int
+}
+
+func (s *CheckpointSink) AddFrame(b Board) error {
+	s.numSeen++
+	if s.Every <= 0 || s.numSeen%s.Every != 0 {
+		return nil
+	}
+
+	checkpoint := s.Run
+	checkpoint.FramesWritten += s.numSeen
+	checkpoint.Board = b
+	return WriteCheckpoint(checkpoint, s.Filename)
+}
+
+func (s *CheckpointSink) Close() error {
+	err := os.Remove(s.Filename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// WriteCheckpoint saves a checkpoint to a file. It writes to a temporary file first and renames it,
+// so an interruption while saving never destroys the previous checkpoint.
+func WriteCheckpoint(checkpoint Checkpoint, filename string) error {
+
+	tmpName := filename + ".tmp"
+	file, err := os.Create(tmpName)
+	if err != nil {
+		return err
+	}
+
+	if err := gob.NewEncoder(file).Encode(checkpoint); err != nil {
+		file.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+	return os.Rename(tmpName, filename)
+}
+
+// ReadCheckpoint loads a checkpoint saved by WriteCheckpoint.
+func ReadCheckpoint(filename string) (Checkpoint, error) {
+
+	var checkpoint Checkpoint
+	file, err := os.Open(filename)
+	if err != nil {
+		return checkpoint, err
+	}
+	defer file.Close()
+
+	if err := gob.NewDecoder(file).Decode(&checkpoint); err != nil {
+		return checkpoint, err
+	}
+	if checkpoint.SnapshotEvery <= 0 {
+		return checkpoint, errors.New(filename + " needs a positive number of sweeps between snapshots")
+	}
+	return checkpoint, nil
+}
+
+// skipFirstFrame is a FrameSink that drops the first frame it is given. A resumed run starts from the
+// board of its checkpoint, which the output of the interrupted run already holds.
+type skipFirstFrame struct {
+	FrameSink
+	skipped bool
+}
+
+func (s *skipFirstFrame) AddFrame(b Board) error {
+	if !s.skipped {
+		s.skipped = true
+		return nil
+	}
+	return s.FrameSink.AddFrame(b)
+}
@@ -22,29 +22,27 @@ func copyBoard(currentBoard Board) Board {
 // Input: a board and its index value in the form of a row and col 
 // Output: a board with the coins dispersed in each cardinal direction if the num of coins is greater than equal to 4 
 func (b Board) Topple(row, col int) {
+	b.ToppleOn(VonNeumann{}, row, col)
+}
+
+// Input: a lattice, a board and its index value in the form of a row and col
+// Output: a board with one coin sent to each of the cell's neighbours on the lattice if the num of coins
+// is greater than equal to the lattice's threshold; coins the lattice's boundary sends off the board are lost
+func (b Board) ToppleOn(lattice Lattice, row, col int) {
 
-	if b[row][col] < 4 {
+	threshold := lattice.Threshold()
+	if b[row][col] < threshold {
 		return
 	}
 
-	b[row][col] -= 4
+	b[row][col] -= threshold
 	numRows := len(b)
 	numCols := len(b[0])
 
-	// West 
-	if row > 0 {
-		b[row-1][col]++
-	}
-	// East
-	if row < numRows-1 {
-		b[row+1][col]++
-	}
-	// South
-	if col > 0 {
-		b[row][col-1]++
-	}
-	// North
-	if col < numCols-1 {
-		b[row][col+1]++
+	for _, offset := range lattice.Neighbours(row, col) {
+		r, c, ok := lattice.Boundary(row+offset[0], col+offset[1], numRows, numCols)
+		if ok {
+			b[r][c]++
+		}
 	}
 }
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigOptions holds the settings an initial configuration may use. Each configuration
+// only reads the fields it needs.
+type ConfigOptions struct {
+	NumCoins int
+	Rng      *rand.Rand
+	NumSites int      // number of sites for random-k-sites
+	Sources  [][2]int // (row, col) of every source for point-sources
+	File     string   // image for image-mask, board file (csv, txt or png) for csv
+}
+
+// InitialConfig places coins on an empty board.
+type InitialConfig func(board Board, opts ConfigOptions) error
+
+// initialConfigs maps the name of every initial configuration to its generator.
+// csv is missing here as it decides the size of the board itself; see NewInitialBoard.
+var initialConfigs = map[string]InitialConfig{
+	"central":        centralConfig,
+	"random-uniform": randomUniformConfig,
+	"random-k-sites": randomSitesConfig,
+	"random":         randomSitesConfig, // the original name of random-k-sites
+	"point-sources":  pointSourcesConfig,
+	"image-mask":     imageMaskConfig,
+}
+
+// ConfigNames returns the names of all initial configurations in alphabetical order.
+func ConfigNames() []string {
+	names := []string{"csv"}
+	for name := range initialConfigs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewInitialBoard takes the name of an initial configuration, the size of the board and its options.
+// It returns a numRows x numCols board set up by that configuration, except for csv which
+// returns the board stored in opts.File whatever its size, read with ReadBoard.
+func NewInitialBoard(name string, numRows, numCols int, opts ConfigOptions) (Board, error) {
+
+	if name == "csv" {
+		return ReadBoard(opts.File)
+	}
+
+	config, ok := initialConfigs[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown initial configuration %q, must be one of %s", name, strings.Join(ConfigNames(), ", "))
+	}
+
+	board := make(Board, numRows)
+	for i := range board {
+		board[i] = make([]int, numCols)
+	}
+	if err := config(board, opts); err != nil {
+		return nil, err
+	}
+	return board, nil
+}
+
+// centralConfig places every coin on the centre cell.
+func centralConfig(board Board, opts ConfigOptions) error {
+	board[len(board)/2][len(board[0])/2] = opts.NumCoins
+	return nil
+}
+
+// randomUniformConfig drops every coin on its own uniformly random cell.
+func randomUniformConfig(board Board, opts ConfigOptions) error {
+	for i := 0; i < opts.NumCoins; i++ {
+		board[opts.Rng.Intn(len(board))][opts.Rng.Intn(len(board[0]))]++
+	}
+	return nil
+}
+
+// randomSitesConfig picks NumSites random cells and drops every coin on one of them at random.
+func randomSitesConfig(board Board, opts ConfigOptions) error {
+
+	if opts.NumSites <= 0 {
+		return errors.New("random-k-sites needs a positive number of sites")
+	}
+
+	positions := make([][2]int, opts.NumSites)
+	for i := range positions {
+		positions[i] = [2]int{opts.Rng.Intn(len(board)), opts.Rng.Intn(len(board[0]))}
+	}
+	for i := 0; i < opts.NumCoins; i++ {
+		position := positions[opts.Rng.Intn(len(positions))]
+		board[position[0]][position[1]]++
+	}
+	return nil
+}
+
+// pointSourcesConfig splits the coins evenly between the given sources, with any remainder
+// going one coin each to the first sources.
+func pointSourcesConfig(board Board, opts ConfigOptions) error {
+
+	if len(opts.Sources) == 0 {
+		return errors.New("point-sources needs at least one source")
+	}
+	for _, source := range opts.Sources {
+		if source[0] < 0 || source[0] >= len(board) || source[1] < 0 || source[1] >= len(board[0]) {
+			return fmt.Errorf("source %d,%d is off the board", source[0], source[1])
+		}
+	}
+
+	share := opts.NumCoins / len(opts.Sources)
+	remainder := opts.NumCoins % len(opts.Sources)
+	for i, source := range opts.Sources {
+		board[source[0]][source[1]] += share
+		if i < remainder {
+			board[source[0]][source[1]]++
+		}
+	}
+	return nil
+}
+
+// imageMaskConfig scales the image in opts.File onto the board and spreads the coins evenly over
+// the cells whose pixel is darker than mid-gray, so a black shape drawn on white becomes the pile.
+// Coins left over after the even split go to random cells of the shape.
+func imageMaskConfig(board Board, opts ConfigOptions) error {
+
+	mask, err := readImageMask(opts.File, len(board), len(board[0]))
+	if err != nil {
+		return err
+	}
+
+	cells := make([][2]int, 0)
+	for r := range mask {
+		for c := range mask[r] {
+			if mask[r][c] {
+				cells = append(cells, [2]int{r, c})
+			}
+		}
+	}
+	if len(cells) == 0 {
+		return errors.New("image mask " + opts.File + " has no dark pixels")
+	}
+
+	share := opts.NumCoins / len(cells)
+	for _, cell := range cells {
+		board[cell[0]][cell[1]] += share
+	}
+	for i := 0; i < opts.NumCoins%len(cells); i++ {
+		cell := cells[opts.Rng.Intn(len(cells))]
+		board[cell[0]][cell[1]]++
+	}
+	return nil
+}
+
+// Input: the name of an image file and the size of the board it is scaled onto
+// Output: a numRows x numCols mask that is true where the nearest pixel is darker than mid-gray
+func readImageMask(filename string, numRows, numCols int) ([][]bool, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := img.Bounds()
+	mask := make([][]bool, numRows)
+	for r := range mask {
+		mask[r] = make([]bool, numCols)
+		y := bounds.Min.Y + r*bounds.Dy()/numRows
+		for c := range mask[r] {
+			x := bounds.Min.X + c*bounds.Dx()/numCols
+			red, green, blue, _ := img.At(x, y).RGBA()
+			// Luminance with the usual Rec. 601 weights, on the 0 to 65535 scale RGBA returns
+			luminance := 0.299*float64(red) + 0.587*float64(green) + 0.114*float64(blue)
+			mask[r][c] = luminance < 32768
+		}
+	}
+	return mask, nil
+}
+
+// ParseSources takes a list of sources written as row,col;row,col;...
+// It returns the (row, col) of every source.
+func ParseSources(text string) ([][2]int, error) {
+
+	sources := make([][2]int, 0)
+	if strings.TrimSpace(text) == "" {
+		return sources, nil
+	}
+
+	for _, part := range strings.Split(text, ";") {
+		coords := strings.Split(strings.TrimSpace(part), ",")
+		if len(coords) != 2 {
+			return nil, fmt.Errorf("source %q must be row,col", part)
+		}
+		row, err1 := strconv.Atoi(strings.TrimSpace(coords[0]))
+		col, err2 := strconv.Atoi(strings.TrimSpace(coords[1]))
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("source %q must be row,col", part)
+		}
+		sources = append(sources, [2]int{row, col})
+	}
+	return sources, nil
+}
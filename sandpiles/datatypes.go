@@ -2,3 +2,13 @@ package main
 
 // Board is a matrix of integers
 type Board [][]int
+
+// band is the part of a Board owned by one processor in the parallel simulation.
+// cells holds the rows [start, end) of the board with an extra ghost row above and below
+// that collect the coins toppled across the edge of the band. odometer holds the same rows
+// of the run's odometer, or is nil if topples are not being counted.
+type band struct {
+	start, end int
+	cells      Board
+	odometer   Board
+}
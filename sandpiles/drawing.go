@@ -3,12 +3,37 @@ package main
 import (
 	"canvas"
 	"image"
-	"math"
+	"image/color"
+	"strconv"
 )
 
-// AnimateBoards takes a slice of Board objects along with a cell width parameter.
-// It generates a slice of images corresponding to drawing each Board on a canvas with the given cell width.
-func AnimateBoards(timePoints []Board, cellWidth int) []image.Image {
+// DrawOptions controls how boards are drawn.
+type DrawOptions struct {
+	CellWidth    int     // width of each cell in pixels
+	Palette      Palette // colours of the cells, GrayPalette when nil
+	Shape        string  // circle, the default, or square
+	Mask         Mask    // inactive cells are drawn in black; nil draws every cell
+	Legend       bool    // add a strip below the board showing the colour of each value
+	FrameCounter bool    // write the number of the frame in the top left corner
+}
+
+// digitFont holds a 3x5 pixel glyph for every character the legend and frame counter use.
+var digitFont = map[rune][5]string{
+	'0': {"###", "#.#", "#.#", "#.#", "###"},
+	'1': {".#.", "##.", ".#.", ".#.", "###"},
+	'2': {"###", "..#", "###", "#..", "###"},
+	'3': {"###", "..#", "###", "..#", "###"},
+	'4': {"#.#", "#.#", "###", "..#", "..#"},
+	'5': {"###", "#..", "###", "..#", "###"},
+	'6': {"###", "#..", "###", "#.#", "###"},
+	'7': {"###", "..#", ".#.", ".#.", ".#."},
+	'8': {"###", "#.#", "###", "#.#", "###"},
+	'9': {"###", "#.#", "###", "..#", "###"},
+}
+
+// AnimateBoards takes a slice of Board objects along with the options to draw them with.
+// It generates a slice of images corresponding to drawing each Board on a canvas, numbering the frames from 0.
+func AnimateBoards(timePoints []Board, opts DrawOptions) []image.Image {
 
 	images := make([]image.Image, len(timePoints))
 
@@ -18,15 +43,15 @@ func AnimateBoards(timePoints []Board, cellWidth int) []image.Image {
 
 	// for every universe, draw to canvas and grab the image
 	for i := range timePoints {
-		images[i] = timePoints[i].DrawToImage(cellWidth)
+		images[i] = timePoints[i].DrawFrame(opts, i)
 	}
 
 	return images
 }
 
-// AnimateBoardsParallel takes a slice of Board objects along with a cell width parameter.
-// It generates a slice of images by drawing each Board on a canvas with the given cell width, using parallel processing.
-func AnimateBoardsParallel(timePoints []Board, cellWidth int, numProcs int) []image.Image {
+// AnimateBoardsParallel takes a slice of Board objects along with the options to draw them with.
+// It generates a slice of images by drawing each Board on a canvas, using parallel processing.
+func AnimateBoardsParallel(timePoints []Board, opts DrawOptions, numProcs int) []image.Image {
 
 	images := make([]image.Image, len(timePoints))
 	finished := make(chan bool, numProcs)
@@ -44,7 +69,7 @@ func AnimateBoardsParallel(timePoints []Board, cellWidth int, numProcs int) []im
 		if i == numProcs-1 {
 			endIndex = numFrames
 		}
-		go animateChunk(timePoints, startIndex, endIndex, cellWidth, images, finished)
+		go animateChunk(timePoints, startIndex, endIndex, opts, images, finished)
 	}
 
 	for i := 0; i < numProcs; i++ {
@@ -54,10 +79,10 @@ func AnimateBoardsParallel(timePoints []Board, cellWidth int, numProcs int) []im
 }
 
 // animateFramesChunk draws a range of frames to the images slice
-func animateChunk(timePoints []Board, start, end int, cellWidth int, images []image.Image, finished chan bool) {
+func animateChunk(timePoints []Board, start, end int, opts DrawOptions, images []image.Image, finished chan bool) {
 
 	for i := start; i < end; i++ {
-		images[i] = timePoints[i].DrawToImage(cellWidth)
+		images[i] = timePoints[i].DrawFrame(opts, i)
 	}
 	finished <- true
 }
@@ -65,25 +90,47 @@ func animateChunk(timePoints []Board, start, end int, cellWidth int, images []im
 // DrawToImage is a Board method.
 // Input: an integer cellWidth
 // Output: the image.Image object corresponding to drawing the board
-// on a square canvas, where each cell has width cellWidth.
+// on a canvas, where each cell has width cellWidth.
 func (b Board) DrawToImage(cellWidth int) image.Image {
+	return b.DrawFrame(DrawOptions{CellWidth: cellWidth}, 0)
+}
+
+// DrawFrame is a Board method.
+// Input: the options to draw with and the number of the frame, used by the frame counter
+// Output: the image.Image object corresponding to drawing the board on a canvas, where each cell
+// has width opts.CellWidth, followed by the legend strip if there is one.
+func (b Board) DrawFrame(opts DrawOptions, frame int) image.Image {
 	if b == nil {
 		panic("Can't Draw a nil board.")
 	}
+	if opts.CellWidth <= 0 {
+		panic("Error: cell width must be positive.")
+	}
+	colors := opts.Palette
+	if colors == nil {
+		colors = GrayPalette{}
+	}
+
+	cellWidth := opts.CellWidth
 	numRows := len(b)
-	canvasWidth := numRows * cellWidth
+	numCols := len(b[0])
+	canvasWidth := numCols * cellWidth
+	boardHeight := numRows * cellWidth
+	// text is drawn with blocks of scale x scale pixels so it stays readable on large boards
+	scale := 1 + canvasWidth/300
+	canvasHeight := boardHeight
+	if opts.Legend {
+		canvasHeight += 9 * scale
+	}
 
-	// create a new square canvas
-	c := canvas.CreateNewCanvas(canvasWidth, canvasWidth)
+	// create a new canvas, which is only square for square boards
+	c := canvas.CreateNewCanvas(canvasWidth, canvasHeight)
 
-	darkGray := canvas.MakeColor(30, 30, 30)
-	gray := canvas.MakeColor(95, 95, 95)
-	lightGray := canvas.MakeColor(190, 190, 190)
-	white := canvas.MakeColor(255, 255, 255)
+	black := canvas.MakeColor(0, 0, 0)
 
-	// create a black background
-	c.SetFillColor(darkGray)
-	c.ClearRect(0, 0, canvasWidth, canvasWidth)
+	// fill the background with the colour of empty cells
+	c.SetFillColor(colors.Color(0))
+	c.ClearRect(0, 0, canvasWidth, boardHeight)
 	c.Fill()
 
 	// range over all the bodies and draw them.
@@ -91,16 +138,23 @@ func (b Board) DrawToImage(cellWidth int) image.Image {
 		for j := range b[i] {
 			val := b[i][j]
 
+			// cells outside the domain are filled in black
+			if opts.Mask.Inactive(i, j) {
+				c.SetFillColor(black)
+				c.ClearRect(j*cellWidth, i*cellWidth, (j+1)*cellWidth, (i+1)*cellWidth)
+				c.Fill()
+				continue
+			}
+
 			if val == 0 {
-				c.SetFillColor(darkGray)
-			} else if val == 1 {
-				c.SetFillColor(gray)
-			} else if val == 2 {
-				c.SetFillColor(lightGray)
-			} else if val == 3 {
-				c.SetFillColor(white)
-			} else {
-				c.SetFillColor(canvas.MakeColor(255, uint8(255-math.Min(255, 40*math.Log2(float64(val-3)))), uint8(255-math.Min(255, 80*math.Log2(float64(val-3))))))
+				continue
+			}
+			c.SetFillColor(colors.Color(val))
+
+			if opts.Shape == "square" {
+				c.ClearRect(j*cellWidth, i*cellWidth, (j+1)*cellWidth, (i+1)*cellWidth)
+				c.Fill()
+				continue
 			}
 
 			// set central coordinates
@@ -109,13 +163,72 @@ func (b Board) DrawToImage(cellWidth int) image.Image {
 
 			scalingFactor := 0.8 // to make circle smaller
 
-			if val > 0 {
-				c.Circle(x, y, scalingFactor*float64(cellWidth)/2)
-				c.Fill()
-			}
+			c.Circle(x, y, scalingFactor*float64(cellWidth)/2)
+			c.Fill()
 		}
 	}
+
+	if opts.Legend {
+		drawLegend(&c, colors, boardHeight, canvasWidth, scale)
+	}
+	if opts.FrameCounter {
+		label := strconv.Itoa(frame)
+		c.SetFillColor(black)
+		c.ClearRect(0, 0, (4*len(label)+3)*scale, 9*scale)
+		c.Fill()
+		drawText(&c, label, 2*scale, 2*scale, scale, canvas.MakeColor(255, 255, 255))
+	}
+
 	// we want to return an image!
 	//c.SaveToPNG("sandpile.png")
 	return c.GetImage()
 }
+
+// drawLegend fills the strip of the canvas below the board, starting at row top, with a swatch
+// and label for the values 0 to colors.LegendMax(). At most eight values are shown, evenly spaced,
+// and the legend stops early if the canvas is too narrow for the rest.
+func drawLegend(c *canvas.Canvas, colors Palette, top, canvasWidth, scale int) {
+
+	c.SetFillColor(canvas.MakeColor(0, 0, 0))
+	c.ClearRect(0, top, canvasWidth, top+9*scale)
+	c.Fill()
+
+	maxValue := colors.LegendMax()
+	step := 1 + maxValue/8
+	x := 2 * scale
+	for val := 0; val <= maxValue; val += step {
+		label := strconv.Itoa(val)
+		width := (8 + 4*len(label)) * scale
+		if x+width > canvasWidth {
+			break
+		}
+
+		c.SetFillColor(colors.Color(val))
+		c.ClearRect(x, top+2*scale, x+5*scale, top+7*scale)
+		c.Fill()
+		drawText(c, label, x+7*scale, top+2*scale, scale, canvas.MakeColor(255, 255, 255))
+		x += width
+	}
+}
+
+// drawText writes a string of digits with its top left corner at (x, y), using digitFont
+// with every font pixel drawn as a scale x scale block. Characters missing from the font are skipped.
+func drawText(c *canvas.Canvas, text string, x, y, scale int, col color.Color) {
+
+	c.SetFillColor(col)
+	for k, char := range text {
+		glyph, ok := digitFont[char]
+		if !ok {
+			continue
+		}
+		left := x + 4*k*scale
+		for r, line := range glyph {
+			for s, pixel := range line {
+				if pixel == '#' {
+					c.ClearRect(left+s*scale, y+r*scale, left+(s+1)*scale, y+(r+1)*scale)
+					c.Fill()
+				}
+			}
+		}
+	}
+}
@@ -5,12 +5,24 @@ package main
 
 import (
 	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 type copyTest struct {
@@ -32,7 +44,7 @@ func TestSerial(t *testing.T) {
 	tests := readSimulateTests("Tests/simulateSerial")
 
 	for i, test := range tests {
-		finalBoards := SimulateSandpiles(test.board)
+		finalBoards := simulateSerial(test.board, VonNeumann{})
 		got := finalBoards[len(finalBoards)-1]
 
 		if !boardsEqual(got, test.result) {
@@ -46,7 +58,7 @@ func TestParallel(t *testing.T) {
 	tests := readSimulateTests("Tests/simulateParallel")
 	numProcs := runtime.NumCPU()
 	for i, test := range tests {
-		finalBoards := SimulateSandpilesParallel(test.board, numProcs)
+		finalBoards := simulateParallel(test.board, numProcs, VonNeumann{})
 		got := finalBoards[len(finalBoards)-1]
 
 		if !boardsEqual(got, test.result) {
@@ -56,6 +68,1271 @@ func TestParallel(t *testing.T) {
 	}
 }
 
+func TestParallelMatchesSerial(t *testing.T) {
+	boards := []Board{
+		centralBoard(41, 41, 5000),
+		randomBoard(37, 53, 20000, 1),
+		randomBoard(64, 64, 30000, 2),
+	}
+	// Nothing falls off a torus, so its boards are kept sparse enough to stabilize
+	torusBoards := []Board{
+		randomBoard(40, 30, 1500, 3),
+		randomBoard(16, 16, 300, 4),
+	}
+	lattices := []Lattice{VonNeumann{}, Moore{}, Hexagonal{}, Torus{VonNeumann{}}, Torus{Moore{}}, Torus{Hexagonal{}}}
+
+	for _, lattice := range lattices {
+		latticeBoards := boards
+		if _, ok := lattice.(Torus); ok {
+			latticeBoards = torusBoards
+		}
+
+		for i, board := range latticeBoards {
+			serialBoards := simulateSerial(copyBoard(board), lattice)
+			want := serialBoards[len(serialBoards)-1]
+
+			// Includes more processors than rows to check that bands are never empty
+			for _, numProcs := range []int{1, 2, 3, 8, 100} {
+				finalBoards := simulateParallel(copyBoard(board), numProcs, lattice)
+				got := finalBoards[len(finalBoards)-1]
+
+				if !boardsEqual(got, want) {
+					t.Errorf("Parallel Matches Serial Test %d on %T with %d procs failed:\nGot:\n%v\nWant:\n%v",
+						i, lattice, numProcs, boardToString(got), boardToString(want))
+				}
+			}
+		}
+	}
+}
+
+func TestCheckerboardMatchesReference(t *testing.T) {
+	boards := []Board{
+		centralBoard(21, 21, 1000),
+		randomBoard(17, 23, 3000, 1),
+		centralBoard(1, 5, 30),
+	}
+	torusBoards := []Board{
+		randomBoard(20, 14, 400, 3),
+		randomBoard(15, 9, 200, 4),
+	}
+	lattices := []Lattice{VonNeumann{}, Moore{}, Hexagonal{}, Torus{VonNeumann{}}, Torus{Moore{}},
+		Masked{Lattice: VonNeumann{}, Mask: DiskMask(21, 21)}}
+
+	for _, lattice := range lattices {
+		latticeBoards := boards
+		if _, ok := lattice.(Torus); ok {
+			latticeBoards = torusBoards
+		}
+		if masked, ok := lattice.(Masked); ok {
+			latticeBoards = []Board{centralBoard(21, 21, 1000)}
+			masked.Mask.Apply(latticeBoards[0])
+		}
+
+		for i, board := range latticeBoards {
+			want := simulateCheckerboard(copyBoard(board), lattice)
+			serialBoards := simulateSerial(copyBoard(board), lattice)
+			if !boardsEqual(want[len(want)-1], serialBoards[len(serialBoards)-1]) {
+				t.Errorf("Checkerboard Test %d on %T failed: final board differs from the serial engine", i, lattice)
+			}
+
+			// Every frame must match, not only the final board
+			for _, numProcs := range []int{1, 2, 3, 30} {
+				got := simulateCheckerboardParallel(copyBoard(board), numProcs, lattice)
+				if len(got) != len(want) {
+					t.Errorf("Checkerboard Test %d on %T with %d procs failed: %d frames, want %d", i, lattice, numProcs, len(got), len(want))
+					continue
+				}
+				for frame := range want {
+					if !boardsEqual(got[frame], want[frame]) {
+						t.Errorf("Checkerboard Test %d on %T with %d procs failed at frame %d:\nGot:\n%v\nWant:\n%v",
+							i, lattice, numProcs, frame, boardToString(got[frame]), boardToString(want[frame]))
+						break
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	holeMask := Mask{{false, false, false}, {false, true, false}, {false, false, false}}
+	tests := []struct {
+		lattice   Lattice
+		coins     int
+		threshold int
+		wantErr   bool
+	}{
+		{Torus{VonNeumann{}}, 27, 4, false},
+		{Torus{VonNeumann{}}, 40, 4, true},
+		{Torus{VonNeumann{}}, 40, 2, true},
+		{VonNeumann{}, 40, 4, false},
+		{Masked{Lattice: Torus{VonNeumann{}}, Mask: Mask{{false, false, false}, {false, false, false}, {false, false, false}}}, 40, 4, true},
+		{Masked{Lattice: Torus{VonNeumann{}}, Mask: holeMask}, 40, 4, false},
+		// the offset rows of a hexagonal torus only line up across an even number of rows
+		{Torus{Hexagonal{}}, 5, 6, true},
+		{Masked{Lattice: Torus{Hexagonal{}}, Mask: holeMask}, 5, 6, true},
+		{Hexagonal{}, 5, 6, false},
+	}
+
+	for i, test := range tests {
+		err := CheckCapacity(centralBoard(3, 3, test.coins), test.lattice, test.threshold)
+		if (err != nil) != test.wantErr {
+			t.Errorf("CheckCapacity Test %d failed: got %v, want an error %v", i, err, test.wantErr)
+		}
+	}
+	if err := CheckCapacity(centralBoard(4, 3, 5), Torus{Hexagonal{}}, 6); err != nil {
+		t.Errorf("CheckCapacity Test failed: a hexagonal torus with 4 rows gave %v", err)
+	}
+}
+
+func TestNeverStable(t *testing.T) {
+	// 40 coins on a 3x3 torus can never stabilize, so every engine must give up rather than loop
+	lattice := Torus{VonNeumann{}}
+	engines := []string{"serial", "parallel", "sparse", "checkerboard-serial", "checkerboard-parallel"}
+	for _, engine := range engines {
+		err := simulateEngine(engine, centralBoard(3, 3, 40), 2, lattice, 1, discardSink{}, nil)
+		if err != errNeverStable {
+			t.Errorf("Never Stable Test failed: %s gave %v, want %v", engine, err, errNeverStable)
+		}
+	}
+
+	// The other rules have no exact test, so they stop at the sweep limit
+	rules := []ToppleRule{NewRotorRouter(lattice, 3, 3), &Manna{Lattice: lattice, Critical: 2, Rng: rand.New(rand.NewSource(1))}}
+	for _, rule := range rules {
+		if err := SimulateSandpilesRule(centralBoard(3, 3, 40), rule, 1000, discardSink{}, nil); err == nil {
+			t.Errorf("Never Stable Test failed: %T stopped without an error", rule)
+		}
+	}
+
+	// A board that does stabilize on a torus keeps its odometer from before the run
+	odometer := NewOdometer(centralBoard(3, 3, 0))
+	odometer[0][0] = 5
+	board := centralBoard(3, 3, 8)
+	if err := SimulateSandpiles(board, lattice, 1, discardSink{}, odometer); err != nil || boardTotal(board) != 8 {
+		t.Errorf("Never Stable Test failed: a stable torus run gave %v with %d coins, want 8", err, boardTotal(board))
+	}
+	if odometer[0][0] != 5 || odometer[1][1] == 0 {
+		t.Errorf("Never Stable Test failed: the odometer is\n%v", boardToString(odometer))
+	}
+}
+
+func TestColorClasses(t *testing.T) {
+	tests := []struct {
+		lattice          Lattice
+		numRows, numCols int
+		numClasses       int // 0 only checks that no neighbours share a class
+	}{
+		{VonNeumann{}, 6, 7, 2},
+		{Moore{}, 6, 7, 4},
+		{Hexagonal{}, 6, 7, 0},
+		{Torus{VonNeumann{}}, 6, 8, 2},
+		{Torus{VonNeumann{}}, 5, 8, 0},
+		{Torus{Hexagonal{}}, 6, 9, 0},
+	}
+
+	for i, test := range tests {
+		classes, numClasses := colorClasses(test.lattice, test.numRows, test.numCols)
+		if test.numClasses > 0 && numClasses != test.numClasses {
+			t.Errorf("Color Classes Test %d on %T failed: %d classes, want %d", i, test.lattice, numClasses, test.numClasses)
+		}
+		for r := range classes {
+			for c := range classes[r] {
+				for _, offset := range test.lattice.Neighbours(r, c) {
+					nr, nc, ok := test.lattice.Boundary(r+offset[0], c+offset[1], test.numRows, test.numCols)
+					if ok && (nr != r || nc != c) && classes[nr][nc] == classes[r][c] {
+						t.Errorf("Color Classes Test %d on %T failed: neighbours %d,%d and %d,%d share class %d",
+							i, test.lattice, r, c, nr, nc, classes[r][c])
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestToppleOn(t *testing.T) {
+	tests := []struct {
+		lattice  Lattice
+		row, col int
+		board    Board
+		result   Board
+	}{
+		{VonNeumann{}, 0, 0, Board{{5, 0, 0}, {0, 0, 0}, {0, 0, 0}}, Board{{1, 1, 0}, {1, 0, 0}, {0, 0, 0}}},
+		{Moore{}, 1, 1, Board{{0, 0, 0}, {0, 9, 0}, {0, 0, 0}}, Board{{1, 1, 1}, {1, 1, 1}, {1, 1, 1}}},
+		{Moore{}, 1, 1, Board{{0, 0, 0}, {0, 7, 0}, {0, 0, 0}}, Board{{0, 0, 0}, {0, 7, 0}, {0, 0, 0}}},
+		{Hexagonal{}, 1, 1, Board{{0, 0, 0}, {0, 6, 0}, {0, 0, 0}}, Board{{0, 1, 1}, {1, 0, 1}, {0, 1, 1}}},
+		{Hexagonal{}, 2, 1, Board{{0, 0, 0}, {0, 0, 0}, {0, 6, 0}}, Board{{0, 0, 0}, {1, 1, 0}, {1, 0, 1}}},
+		{Torus{VonNeumann{}}, 0, 0, Board{{4, 0, 0}, {0, 0, 0}, {0, 0, 0}}, Board{{0, 1, 1}, {1, 0, 0}, {1, 0, 0}}},
+	}
+
+	for i, test := range tests {
+		test.board.ToppleOn(test.lattice, test.row, test.col)
+		if !boardsEqual(test.board, test.result) {
+			t.Errorf("ToppleOn Test %d on %T failed:\nGot:\n%v\nWant:\n%v",
+				i, test.lattice, boardToString(test.board), boardToString(test.result))
+		}
+	}
+}
+
+func TestSparseMatchesSerial(t *testing.T) {
+	boards := []Board{
+		centralBoard(41, 41, 5000),
+		centralBoard(1, 1, 100),
+		randomBoard(37, 53, 20000, 1),
+	}
+	lattices := []Lattice{VonNeumann{}, Moore{}, Hexagonal{}}
+
+	for _, lattice := range lattices {
+		for i, board := range boards {
+			serialBoards := simulateSerial(copyBoard(board), lattice)
+			want := serialBoards[len(serialBoards)-1]
+
+			finalBoards := simulateSparse(copyBoard(board), lattice)
+			got := finalBoards[len(finalBoards)-1]
+
+			if !boardsEqual(finalBoards[0], board) {
+				t.Errorf("Sparse Test %d on %T failed: first board is not the input board", i, lattice)
+			}
+			if !boardsEqual(got, want) {
+				t.Errorf("Sparse Matches Serial Test %d on %T failed:\nGot:\n%v\nWant:\n%v",
+					i, lattice, boardToString(got), boardToString(want))
+			}
+		}
+	}
+}
+
+func TestMaskedDomains(t *testing.T) {
+	masks := []Mask{
+		DiskMask(31, 45),
+		AnnulusMask(40, 40, 0.4),
+	}
+
+	for i, mask := range masks {
+		board := randomBoard(len(mask), len(mask[0]), 8000, int64(i))
+		mask.Apply(board)
+		lattice := Masked{Lattice: VonNeumann{}, Mask: mask}
+
+		serialBoards := simulateSerial(copyBoard(board), lattice)
+		want := serialBoards[len(serialBoards)-1]
+		parallelBoards := simulateParallel(copyBoard(board), 3, lattice)
+		sparseBoards := simulateSparse(copyBoard(board), lattice)
+
+		if got := parallelBoards[len(parallelBoards)-1]; !boardsEqual(got, want) {
+			t.Errorf("Masked Domain Test %d failed: parallel and serial disagree", i)
+		}
+		if got := sparseBoards[len(sparseBoards)-1]; !boardsEqual(got, want) {
+			t.Errorf("Masked Domain Test %d failed: sparse and serial disagree", i)
+		}
+		for r := range want {
+			for c := range want[r] {
+				if mask[r][c] && want[r][c] != 0 {
+					t.Errorf("Masked Domain Test %d failed: inactive cell %d,%d holds %d coins", i, r, c, want[r][c])
+				}
+				if !mask[r][c] && want[r][c] >= 4 {
+					t.Errorf("Masked Domain Test %d failed: cell %d,%d is unstable", i, r, c)
+				}
+			}
+		}
+	}
+
+	disk := DiskMask(11, 11)
+	if disk[5][5] || disk[0][5] || !disk[0][0] || !disk[10][10] {
+		t.Errorf("DiskMask failed:\n%v", disk)
+	}
+	annulus := AnnulusMask(11, 11, 0.5)
+	if !annulus[5][5] || annulus[5][1] || !annulus[0][0] {
+		t.Errorf("AnnulusMask failed:\n%v", annulus)
+	}
+	if mask, err := MaskFromName("full", 5, 5, 0, ""); mask != nil || err != nil {
+		t.Errorf("MaskFromName failed: full gave %v, %v", mask, err)
+	}
+	if _, err := MaskFromName("annulus", 5, 5, 1.5, ""); err == nil {
+		t.Errorf("MaskFromName failed: inner fraction above 1 gave no error")
+	}
+
+	// Rectangular boards are drawn as wide as their columns and as tall as their rows
+	bounds := centralBoard(3, 7, 10).DrawFrame(DrawOptions{CellWidth: 4, Mask: DiskMask(3, 7)}, 0).Bounds()
+	if bounds.Dx() != 28 || bounds.Dy() != 12 {
+		t.Errorf("DrawFrame failed: image is %dx%d, want 28x12", bounds.Dx(), bounds.Dy())
+	}
+}
+
+func TestPalettes(t *testing.T) {
+	if got := (GrayPalette{}).Color(2); got != (color.RGBA{190, 190, 190, 255}) {
+		t.Errorf("GrayPalette failed: 2 is drawn in %v", got)
+	}
+	if got := ClassicPalette.Color(9); got != ClassicPalette[4] {
+		t.Errorf("ClassicPalette failed: 9 is drawn in %v, want the unstable colour", got)
+	}
+
+	table, err := ParseColorTable("#ff0000, #00ff00")
+	if err != nil || len(table) != 2 || table.Color(5) != (color.RGBA{0, 255, 0, 255}) {
+		t.Errorf("ParseColorTable failed: got %v, %v", table, err)
+	}
+	if _, err := ParseColorTable("#ff00"); err == nil {
+		t.Errorf("ParseColorTable failed: short colour gave no error")
+	}
+
+	smooth, err := PaletteFromName("smooth-blue-red", 3)
+	if err != nil {
+		t.Fatalf("PaletteFromName failed: %v", err)
+	}
+	if smooth.Color(0) == smooth.Color(3) || smooth.Color(10) != smooth.Color(3) || smooth.LegendMax() != 3 {
+		t.Errorf("Continuous Palette failed: 0, 3 and 10 are drawn in %v, %v and %v", smooth.Color(0), smooth.Color(3), smooth.Color(10))
+	}
+	if _, err := PaletteFromName("nonsense", 3); err == nil {
+		t.Errorf("PaletteFromName failed: unknown name gave no error")
+	}
+}
+
+func TestDrawOptions(t *testing.T) {
+	board := Board{{0, 1, 2}, {3, 0, 1}}
+	table := ColorTable{color.RGBA{0, 0, 0, 255}, color.RGBA{255, 0, 0, 255}, color.RGBA{0, 255, 0, 255}, color.RGBA{0, 0, 255, 255}}
+
+	// Square cells are filled right to their corners
+	img := board.DrawFrame(DrawOptions{CellWidth: 4, Palette: table, Shape: "square"}, 0)
+	for r := range board {
+		for c := range board[r] {
+			want := table[board[r][c]]
+			if got := color.RGBAModel.Convert(img.At(c*4, r*4)); got != want {
+				t.Errorf("Square Cells failed: corner of cell %d,%d is %v, want %v", r, c, got, want)
+			}
+		}
+	}
+
+	// The legend adds a strip below the board and the counter writes the frame number over it
+	img = board.DrawFrame(DrawOptions{CellWidth: 4, Palette: table, Legend: true, FrameCounter: true}, 7)
+	if bounds := img.Bounds(); bounds.Dx() != 12 || bounds.Dy() != 17 {
+		t.Errorf("Legend failed: image is %dx%d, want 12x17", bounds.Dx(), bounds.Dy())
+	}
+	if got := color.RGBAModel.Convert(img.At(2, 2)); got != (color.RGBA{255, 255, 255, 255}) {
+		t.Errorf("Frame Counter failed: top of the 7 is %v, want white", got)
+	}
+	if got := color.RGBAModel.Convert(img.At(2, 10)); got != table[0] {
+		t.Errorf("Legend failed: first swatch is %v, want %v", got, table[0])
+	}
+
+	boards := []Board{board, centralBoard(2, 3, 9), randomBoard(2, 3, 20, 1)}
+	opts := DrawOptions{CellWidth: 3, Palette: ClassicPalette, FrameCounter: true}
+	serial := AnimateBoards(boards, opts)
+	parallel := AnimateBoardsParallel(boards, opts, 2)
+	for i := range boards {
+		bounds := serial[i].Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				if serial[i].At(x, y) != parallel[i].At(x, y) {
+					t.Fatalf("Animate Boards Parallel failed: frame %d differs at %d,%d", i, x, y)
+				}
+			}
+		}
+	}
+}
+
+func BenchmarkSerialCentral(b *testing.B) {
+	board := centralBoard(401, 401, 20000)
+	for i := 0; i < b.N; i++ {
+		SimulateSandpiles(copyBoard(board), VonNeumann{}, 500, discardSink{}, nil)
+	}
+}
+
+func BenchmarkSparseCentral(b *testing.B) {
+	board := centralBoard(401, 401, 20000)
+	for i := 0; i < b.N; i++ {
+		SimulateSandpilesSparse(copyBoard(board), VonNeumann{}, discardSink{}, nil)
+	}
+}
+
+func TestOdometer(t *testing.T) {
+	boards := []Board{
+		centralBoard(31, 31, 3000),
+		randomBoard(17, 23, 3000, 1),
+	}
+	lattices := []Lattice{VonNeumann{}, Moore{}, Hexagonal{}, Torus{VonNeumann{}}}
+
+	for _, lattice := range lattices {
+		for i, board := range boards {
+			if _, ok := lattice.(Torus); ok {
+				board = randomBoard(16, 16, 300, int64(i))
+			}
+
+			// Every engine must count the same topples
+			odometers := make([]Board, 5)
+			for k := range odometers {
+				odometers[k] = NewOdometer(board)
+			}
+			final := copyBoard(board)
+			SimulateSandpiles(final, lattice, 500, discardSink{}, odometers[0])
+			SimulateSandpilesParallel(copyBoard(board), 3, lattice, 500, discardSink{}, odometers[1])
+			SimulateSandpilesSparse(copyBoard(board), lattice, discardSink{}, odometers[2])
+			SimulateSandpilesCheckerboard(copyBoard(board), lattice, 500, discardSink{}, odometers[3])
+			SimulateSandpilesCheckerboardParallel(copyBoard(board), 4, lattice, 500, discardSink{}, odometers[4])
+			for k := 1; k < len(odometers); k++ {
+				if !boardsEqual(odometers[k], odometers[0]) {
+					t.Errorf("Odometer Test %d on %T failed: engine %d counted different topples", i, lattice, k)
+				}
+			}
+
+			// The final board is the initial board plus the Laplacian of the odometer
+			want := copyBoard(board)
+			odometer := odometers[0]
+			for r := range odometer {
+				for c := range odometer[r] {
+					want[r][c] -= lattice.Threshold() * odometer[r][c]
+					for _, offset := range lattice.Neighbours(r, c) {
+						nr, nc, ok := lattice.Boundary(r+offset[0], c+offset[1], len(board), len(board[0]))
+						if ok {
+							want[nr][nc] += odometer[r][c]
+						}
+					}
+				}
+			}
+			if !boardsEqual(final, want) {
+				t.Errorf("Odometer Test %d on %T failed: final board is not the initial board plus the Laplacian of the odometer", i, lattice)
+			}
+		}
+	}
+
+	// A single pile topples most at its centre and symmetrically about it
+	odometer := NewOdometer(centralBoard(31, 31, 3000))
+	SimulateSandpilesSparse(centralBoard(31, 31, 3000), VonNeumann{}, discardSink{}, odometer)
+	for r := range odometer {
+		for c := range odometer[r] {
+			if odometer[r][c] > odometer[15][15] || odometer[r][c] != odometer[c][r] || odometer[r][c] != odometer[30-r][c] {
+				t.Fatalf("Odometer Test failed: central pile odometer is not symmetric about its peak at %d,%d", r, c)
+			}
+		}
+	}
+
+	dir := t.TempDir()
+	if err := WriteOdometer(odometer, filepath.Join(dir, "run"), 2, nil); err != nil {
+		t.Fatalf("WriteOdometer failed: %v", err)
+	}
+	saved, err := ReadBoard(filepath.Join(dir, "run_odometer.csv"))
+	if err != nil || !boardsEqual(saved, odometer) {
+		t.Errorf("WriteOdometer failed: CSV does not hold the odometer (%v)", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "run_odometer.png")); err != nil {
+		t.Errorf("WriteOdometer failed: no heatmap written: %v", err)
+	}
+}
+
+func TestToppleRules(t *testing.T) {
+	// The abelian rule runs exactly like the plain serial engine
+	board := randomBoard(17, 23, 3000, 1)
+	want := simulateSerial(copyBoard(board), Moore{})
+	got := &FrameList{}
+	if err := SimulateSandpilesRule(copyBoard(board), Abelian{Moore{}}, 500, got, nil); err != nil || !boardsEqual(got.Boards[len(got.Boards)-1], want[len(want)-1]) {
+		t.Errorf("Abelian Rule failed: final board differs from SimulateSandpiles (%v)", err)
+	}
+
+	// Rotor-router aggregation keeps one chip per cell and, far from the edge, loses none
+	chips := 500
+	rotorBoard := centralBoard(51, 51, chips)
+	rotors := NewRotorRouter(VonNeumann{}, 51, 51)
+	if err := SimulateSandpilesRule(rotorBoard, rotors, 1000, discardSink{}, nil); err != nil {
+		t.Fatalf("Rotor Router failed: %v", err)
+	}
+	radius := math.Sqrt(float64(chips) / math.Pi)
+	for r := range rotorBoard {
+		for c := range rotorBoard[r] {
+			if rotorBoard[r][c] > 1 {
+				t.Errorf("Rotor Router failed: cell %d,%d holds %d chips", r, c, rotorBoard[r][c])
+			}
+			if rotorBoard[r][c] == 1 && math.Hypot(float64(r-25), float64(c-25)) > radius+2 {
+				t.Errorf("Rotor Router failed: cell %d,%d is outside the disk of radius %.1f", r, c, radius)
+			}
+		}
+	}
+	if total := boardTotal(rotorBoard); total != chips {
+		t.Errorf("Rotor Router failed: %d chips left, want %d", total, chips)
+	}
+
+	// Manna piles with the same seed are the same, and different seeds differ
+	manna := func(seed int64) Board {
+		b := centralBoard(41, 41, 300)
+		rule, _ := RuleFromName("manna", VonNeumann{}, 41, 41, seed)
+		SimulateSandpilesRule(b, rule, 1000, discardSink{}, nil)
+		return b
+	}
+	first, again, other := manna(3), manna(3), manna(4)
+	if !boardsEqual(first, again) || boardsEqual(first, other) {
+		t.Errorf("Manna Rule failed: seeds do not decide the final board")
+	}
+	for r := range first {
+		for c := range first[r] {
+			if first[r][c] >= 2 {
+				t.Errorf("Manna Rule failed: cell %d,%d holds %d grains", r, c, first[r][c])
+			}
+		}
+	}
+	if total := boardTotal(first); total != 300 {
+		t.Errorf("Manna Rule failed: %d grains left, want 300", total)
+	}
+
+	// Zhang energies all end below the critical energy, and none is lost far from the edge
+	zhangBoard := centralBoard(41, 41, 40*zhangResolution)
+	zhang, _ := RuleFromName("zhang", VonNeumann{}, 41, 41, 0)
+	if err := SimulateSandpilesRule(zhangBoard, zhang, 1000, discardSink{}, nil); err != nil {
+		t.Fatalf("Zhang Rule failed: %v", err)
+	}
+	for r := range zhangBoard {
+		for c := range zhangBoard[r] {
+			if zhangBoard[r][c] < 0 || zhangBoard[r][c] >= zhangResolution {
+				t.Errorf("Zhang Rule failed: cell %d,%d holds energy %d", r, c, zhangBoard[r][c])
+			}
+		}
+	}
+	if total := boardTotal(zhangBoard); total != 40*zhangResolution {
+		t.Errorf("Zhang Rule failed: total energy %d, want %d", total, 40*zhangResolution)
+	}
+
+	if _, err := RuleFromName("nonsense", VonNeumann{}, 1, 1, 0); err == nil {
+		t.Errorf("RuleFromName failed: unknown name gave no error")
+	}
+}
+
+func TestFrameSinks(t *testing.T) {
+	dir := t.TempDir()
+	board := centralBoard(31, 31, 3000)
+
+	frames := &FrameList{}
+	boardFile, err := NewBoardFileSink(filepath.Join(dir, "frames.sandpile"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pngs := NewPNGSink(filepath.Join(dir, "frame"), DrawOptions{CellWidth: 1}, 0)
+	sink := MultiSink{frames, boardFile, pngs}
+
+	if err := SimulateSandpiles(copyBoard(board), VonNeumann{}, 500, sink, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ReadBoardFile(filepath.Join(dir, "frames.sandpile"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(frames.Boards) {
+		t.Fatalf("Board File Test failed: read %d frames, want %d", len(got), len(frames.Boards))
+	}
+	for i := range got {
+		if !boardsEqual(got[i], frames.Boards[i]) {
+			t.Errorf("Board File Test frame %d failed:\nGot:\n%v\nWant:\n%v", i, boardToString(got[i]), boardToString(frames.Boards[i]))
+		}
+	}
+	if !boardsEqual(frames.Boards[0], board) {
+		t.Errorf("Frame Sink Test failed: first frame is not the input board")
+	}
+
+	pngFiles, err := filepath.Glob(filepath.Join(dir, "frame_*.png"))
+	if err != nil || len(pngFiles) != len(frames.Boards) {
+		t.Errorf("PNG Sink Test failed: wrote %d files, want %d", len(pngFiles), len(frames.Boards))
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "run.ckpt")
+	board := centralBoard(41, 41, 8000)
+
+	full := simulateSerial(copyBoard(board), VonNeumann{})
+
+	// Stops the run once the checkpoint after the second frame has been written
+	interrupted := &FrameList{}
+	checkpoints := &CheckpointSink{Filename: filename, Every: 2, Run: Checkpoint{Lattice: "vonneumann", SnapshotEvery: 500}}
+	err := SimulateSandpiles(copyBoard(board), VonNeumann{}, 500, MultiSink{interrupted, checkpoints, &failAfter{2}}, nil)
+	if err == nil {
+		t.Fatal("Checkpoint Test failed: interrupted run did not stop")
+	}
+
+	checkpoint, err := ReadCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if checkpoint.FramesWritten != 2 || checkpoint.Lattice != "vonneumann" {
+		t.Fatalf("Checkpoint Test failed: got %d frames on %q, want 2 on \"vonneumann\"", checkpoint.FramesWritten, checkpoint.Lattice)
+	}
+
+	resumed := &FrameList{}
+	resumedCheckpoints := &CheckpointSink{Filename: filename, Every: 2, Run: checkpoint}
+	sink := &skipFirstFrame{FrameSink: MultiSink{resumed, resumedCheckpoints}}
+	if err := SimulateSandpiles(checkpoint.Board, VonNeumann{}, 500, sink, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	frames := append(interrupted.Boards[:2], resumed.Boards...)
+	if len(frames) != len(full) {
+		t.Fatalf("Checkpoint Test failed: resumed run has %d frames, want %d", len(frames), len(full))
+	}
+	for i := range frames {
+		if !boardsEqual(frames[i], full[i]) {
+			t.Errorf("Checkpoint Test frame %d failed:\nGot:\n%v\nWant:\n%v", i, boardToString(frames[i]), boardToString(full[i]))
+		}
+	}
+	if _, err := os.Stat(filename); !os.IsNotExist(err) {
+		t.Errorf("Checkpoint Test failed: checkpoint file was not removed after the run finished")
+	}
+
+	// A checkpoint without sweeps between snapshots can't be resumed, and no engine may panic on one
+	broken := &CheckpointSink{Filename: filename, Every: 1, Run: Checkpoint{Lattice: "vonneumann"}}
+	if err := broken.AddFrame(board); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadCheckpoint(filename); err == nil {
+		t.Errorf("Checkpoint Test failed: read a checkpoint with no sweeps between snapshots")
+	}
+	for _, engine := range []string{"serial", "parallel", "checkerboard-serial", "checkerboard-parallel"} {
+		if err := simulateEngine(engine, copyBoard(board), 2, VonNeumann{}, 0, discardSink{}, nil); err != errSnapshotEvery {
+			t.Errorf("Checkpoint Test failed: %s with no sweeps between snapshots gave %v, want %v", engine, err, errSnapshotEvery)
+		}
+	}
+}
+
+func TestResumeBetweenCheckpoints(t *testing.T) {
+	dir := t.TempDir()
+	board := centralBoard(21, 21, 1000)
+	run := Checkpoint{Engine: "serial", NumProcs: 1, SnapshotEvery: 1, Lattice: "vonneumann", Rule: "abelian", Format: "board", CellWidth: 1, Shape: "square"}
+
+	full := run
+	full.Output = filepath.Join(dir, "full")
+	if err := runEngine(full, copyBoard(board), false); err != nil {
+		t.Fatal(err)
+	}
+	want, err := ReadBoardFile(full.Output + ".sandpile")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stops the run 5 frames after its last checkpoint, which the output already holds
+	run.Output = filepath.Join(dir, "resumed")
+	output, err := NewFrameSink(run.Format, run.Output, DrawOptions{}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoints := &CheckpointSink{Filename: run.Output + ".ckpt", Every: checkpointEvery, Run: run}
+	if err := SimulateSandpiles(copyBoard(board), VonNeumann{}, 1, MultiSink{output, checkpoints, &failAfter{2*checkpointEvery + 5}}, nil); err == nil {
+		t.Fatal("Resume Test failed: interrupted run did not stop")
+	}
+	output.Close()
+
+	checkpoint, err := ReadCheckpoint(run.Output + ".ckpt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runEngine(checkpoint, checkpoint.Board, true); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadBoardFile(run.Output + ".sandpile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Resume Test failed: resumed board file has %d frames, want %d", len(got), len(want))
+	}
+	for i := range got {
+		if !boardsEqual(got[i], want[i]) {
+			t.Fatalf("Resume Test frame %d failed:\nGot:\n%v\nWant:\n%v", i, boardToString(got[i]), boardToString(want[i]))
+		}
+	}
+
+	// The PNG frames written after the checkpoint are removed, and the earlier ones kept
+	prefix := filepath.Join(dir, "frame")
+	for i := 0; i < 6; i++ {
+		if err := os.WriteFile(fmt.Sprintf("%s_%05d.png", prefix, i), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := NewFrameSink("png", prefix, DrawOptions{CellWidth: 1}, 3); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 6; i++ {
+		_, err := os.Stat(fmt.Sprintf("%s_%05d.png", prefix, i))
+		if exists := err == nil; exists != (i < 3) {
+			t.Errorf("Resume Test failed: PNG frame %d exists %v, want %v", i, exists, i < 3)
+		}
+	}
+	if _, err := NewBoardFileSink(filepath.Join(dir, "missing.sandpile"), 3); err == nil {
+		t.Errorf("Resume Test failed: resumed a board file that does not exist")
+	}
+}
+
+// failAfter is a FrameSink that fails once it has been given more than remaining frames
+type failAfter struct {
+	remaining int
+}
+
+func (f *failAfter) AddFrame(b Board) error {
+	f.remaining--
+	if f.remaining < 0 {
+		return errors.New("interrupted")
+	}
+	return nil
+}
+
+func (f *failAfter) Close() error { return nil }
+
+func TestInitialConfigs(t *testing.T) {
+	dir := t.TempDir()
+
+	csvFile := filepath.Join(dir, "board.csv")
+	if err := os.WriteFile(csvFile, []byte("1,2,3\n4,5,6\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// A black square in the top left quarter of a white image
+	maskFile := filepath.Join(dir, "mask.png")
+	img := image.NewGray(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			if x >= 10 || y >= 10 {
+				img.SetGray(x, y, color.Gray{255})
+			}
+		}
+	}
+	file, err := os.Create(maskFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(file, img)
+	file.Close()
+
+	for _, name := range ConfigNames() {
+		opts := ConfigOptions{
+			NumCoins: 1001,
+			Rng:      rand.New(rand.NewSource(7)),
+			NumSites: 5,
+			Sources:  [][2]int{{0, 0}, {9, 9}},
+		}
+		switch name {
+		case "csv":
+			opts.File = csvFile
+		case "image-mask":
+			opts.File = maskFile
+		}
+
+		board, err := NewInitialBoard(name, 10, 10, opts)
+		if err != nil {
+			t.Errorf("Initial Config %s failed: %v", name, err)
+			continue
+		}
+
+		total, nonZero := 0, 0
+		for r := range board {
+			for c := range board[r] {
+				total += board[r][c]
+				if board[r][c] > 0 {
+					nonZero++
+				}
+			}
+		}
+
+		switch name {
+		case "csv":
+			if want := (Board{{1, 2, 3}, {4, 5, 6}}); !boardsEqual(board, want) {
+				t.Errorf("Initial Config csv failed:\nGot:\n%v\nWant:\n%v", boardToString(board), boardToString(want))
+			}
+			continue
+		case "central":
+			if board[5][5] != 1001 {
+				t.Errorf("Initial Config central failed: centre holds %d coins", board[5][5])
+			}
+		case "random", "random-k-sites":
+			if nonZero > 5 {
+				t.Errorf("Initial Config %s failed: %d cells hold coins, want at most 5", name, nonZero)
+			}
+		case "point-sources":
+			if board[0][0] != 501 || board[9][9] != 500 {
+				t.Errorf("Initial Config point-sources failed: sources hold %d and %d coins", board[0][0], board[9][9])
+			}
+		case "image-mask":
+			for r := range board {
+				for c := range board[r] {
+					if (r < 5 && c < 5) != (board[r][c] >= 40) {
+						t.Errorf("Initial Config image-mask failed: cell %d,%d holds %d coins", r, c, board[r][c])
+					}
+				}
+			}
+		}
+		if total != 1001 {
+			t.Errorf("Initial Config %s failed: board holds %d coins, want 1001", name, total)
+		}
+
+		// The same seed must give the same board
+		opts.Rng = rand.New(rand.NewSource(7))
+		again, _ := NewInitialBoard(name, 10, 10, opts)
+		if !boardsEqual(board, again) {
+			t.Errorf("Initial Config %s failed: same seed gave different boards", name)
+		}
+	}
+
+	if _, err := NewInitialBoard("nonsense", 10, 10, ConfigOptions{}); err == nil {
+		t.Errorf("Initial Config failed: unknown name gave no error")
+	}
+}
+
+func TestParseSources(t *testing.T) {
+	sources, err := ParseSources("1,2; 30,4")
+	if err != nil || len(sources) != 2 || sources[0] != [2]int{1, 2} || sources[1] != [2]int{30, 4} {
+		t.Errorf("ParseSources failed: got %v, %v", sources, err)
+	}
+	if _, err := ParseSources("1;2"); err == nil {
+		t.Errorf("ParseSources failed: malformed sources gave no error")
+	}
+}
+
+func TestReadWriteBoard(t *testing.T) {
+	dir := t.TempDir()
+	boards := []Board{
+		{{0, 1, 2}, {3, 255, 0}},
+		{{0, 256}, {65535, 7}, {1, 1}},
+		randomBoard(13, 17, 2000, 8),
+	}
+
+	for i, board := range boards {
+		for _, ext := range []string{"csv", "txt", "png"} {
+			filename := filepath.Join(dir, "board."+ext)
+			if err := WriteBoard(board, filename); err != nil {
+				t.Errorf("WriteBoard Test %d (%s) failed: %v", i, ext, err)
+				continue
+			}
+			got, err := ReadBoard(filename)
+			if err != nil {
+				t.Errorf("ReadBoard Test %d (%s) failed: %v", i, ext, err)
+				continue
+			}
+			if !boardsEqual(got, board) {
+				t.Errorf("Read/Write Board Test %d (%s) failed:\nGot:\n%v\nWant:\n%v", i, ext, boardToString(got), boardToString(board))
+			}
+		}
+	}
+
+	if err := WriteBoard(Board{{70000}}, filepath.Join(dir, "big.png")); err == nil {
+		t.Errorf("WriteBoard Test failed: a cell too large for a PNG gave no error")
+	}
+	if err := WriteBoard(Board{{1}}, filepath.Join(dir, "board.xyz")); err == nil {
+		t.Errorf("WriteBoard Test failed: unknown extension gave no error")
+	}
+
+	ragged := filepath.Join(dir, "ragged.txt")
+	os.WriteFile(ragged, []byte("1 2 3\n4 5\n"), 0644)
+	if _, err := ReadBoard(ragged); err == nil {
+		t.Errorf("ReadBoard Test failed: ragged board gave no error")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	sizes := [][2]int{{1, 1}, {3, 3}, {4, 7}, {25, 25}}
+
+	for i, size := range sizes {
+		identity := Identity(size[0], size[1])
+
+		if !IsRecurrent(identity) {
+			t.Errorf("Identity Test %d failed: identity is not recurrent:\n%v", i, boardToString(identity))
+		}
+		if got := Add(identity, identity); !boardsEqual(got, identity) {
+			t.Errorf("Identity Test %d failed: e + e != e\nGot:\n%v\nWant:\n%v",
+				i, boardToString(got), boardToString(identity))
+		}
+
+		// Adding the identity to any recurrent board leaves it unchanged
+		recurrent := Add(identity, randomBoard(size[0], size[1], 10*size[0]*size[1], int64(i)))
+		if got := Add(recurrent, identity); !boardsEqual(got, recurrent) {
+			t.Errorf("Identity Test %d failed: b + e != b\nGot:\n%v\nWant:\n%v",
+				i, boardToString(got), boardToString(recurrent))
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	a := randomBoard(9, 11, 300, 1)
+	b := randomBoard(9, 11, 300, 2)
+	aCopy := copyBoard(a)
+
+	if !boardsEqual(Add(a, b), Add(b, a)) {
+		t.Errorf("Add Test failed: a + b != b + a")
+	}
+	if !boardsEqual(a, aCopy) {
+		t.Errorf("Add Test failed: Add modified its input")
+	}
+}
+
+func TestIsRecurrent(t *testing.T) {
+	tests := []struct {
+		board  Board
+		result bool
+	}{
+		{Board{{3, 3}, {3, 3}}, true},
+		{Board{{0, 0}, {0, 0}}, false},
+		{Board{{2, 3}, {3, 3}}, true},
+		{Board{{1, 0}, {0, 3}}, false},
+		{Board{{3, 4}, {3, 3}}, false},
+	}
+
+	for i, test := range tests {
+		if got := IsRecurrent(test.board); got != test.result {
+			t.Errorf("IsRecurrent Test %d failed: got %v, want %v for\n%v", i, got, test.result, boardToString(test.board))
+		}
+	}
+}
+
+func TestDriveSandpile(t *testing.T) {
+	lattices := []Lattice{VonNeumann{}, Moore{}, Hexagonal{}}
+
+	for _, lattice := range lattices {
+		board := randomBoard(15, 12, 400, 5)
+		simulateSerial(board, lattice)
+		reference := copyBoard(board)
+
+		avalanches, err := DriveSandpile(board, lattice, 300, RandomSites(rand.New(rand.NewSource(6)), 15, 12))
+		if err != nil {
+			t.Fatalf("DriveSandpile on %T failed: %v", lattice, err)
+		}
+
+		// Relaxing after every grain must end where the sweep simulation ends
+		rng := rand.New(rand.NewSource(6))
+		for i, a := range avalanches {
+			row, col := rng.Intn(15), rng.Intn(12)
+			if a.Row != row || a.Col != col {
+				t.Fatalf("DriveSandpile on %T dropped grain %d at (%d, %d), want (%d, %d)", lattice, i, a.Row, a.Col, row, col)
+			}
+			reference[row][col]++
+			simulateSerial(reference, lattice)
+
+			if a.Area > a.Size || (a.Size == 0) != (a.Duration == 0) || (a.Size == 0 && a.Extent != 0) {
+				t.Errorf("DriveSandpile on %T gave inconsistent avalanche %d: %+v", lattice, i, a)
+			}
+		}
+		if !boardsEqual(board, reference) {
+			t.Errorf("DriveSandpile on %T failed:\nGot:\n%v\nWant:\n%v", lattice, boardToString(board), boardToString(reference))
+		}
+	}
+
+	// Grains never leave a torus, so driving one would end in an avalanche that never stops
+	if _, err := DriveSandpile(centralBoard(3, 3, 0), Torus{VonNeumann{}}, 40, FixedSite(1, 1)); err == nil {
+		t.Errorf("DriveSandpile on a torus gave no error")
+	}
+}
+
+func TestRelax(t *testing.T) {
+	board := Board{{3, 3, 3}, {3, 3, 3}, {3, 3, 3}}
+	board[1][1]++
+	got := relax(board, VonNeumann{}, 1, 1)
+	want := Avalanche{Row: 1, Col: 1, Size: 10, Area: 9, Duration: 3, Extent: 1.4142135623730951}
+
+	if got != want {
+		t.Errorf("Relax Test failed: got %+v, want %+v", got, want)
+	}
+	if result := (Board{{1, 3, 1}, {3, 0, 3}, {1, 3, 1}}); !boardsEqual(board, result) {
+		t.Errorf("Relax Test failed:\nGot:\n%v\nWant:\n%v", boardToString(board), boardToString(result))
+	}
+}
+
+func TestLogHistogram(t *testing.T) {
+	bins := LogHistogram([]int{0, 1, 2, 3, 4, 5, 6, 7, 8}, 2)
+	want := []HistogramBin{
+		{Lower: 1, Upper: 2, Count: 1, Density: 1.0 / 8},
+		{Lower: 2, Upper: 4, Count: 2, Density: 1.0 / 8},
+		{Lower: 4, Upper: 8, Count: 4, Density: 1.0 / 8},
+		{Lower: 8, Upper: 16, Count: 1, Density: 1.0 / 64},
+	}
+
+	if len(bins) != len(want) {
+		t.Fatalf("LogHistogram Test failed: got %d bins, want %d", len(bins), len(want))
+	}
+	for i := range bins {
+		if bins[i] != want[i] {
+			t.Errorf("LogHistogram Test bin %d failed: got %+v, want %+v", i, bins[i], want[i])
+		}
+	}
+}
+
+func TestPowerLawExponent(t *testing.T) {
+	bins := make([]HistogramBin, 0)
+	for lower := 1; lower < 5000; lower *= 3 {
+		upper := 3 * lower
+		centre := math.Sqrt(float64(lower * (upper - 1)))
+		bins = append(bins, HistogramBin{Lower: lower, Upper: upper, Count: 1, Density: math.Pow(centre, -1.5)})
+	}
+
+	if got := PowerLawExponent(bins); math.Abs(got-1.5) > 1e-9 {
+		t.Errorf("PowerLawExponent Test failed: got %v, want 1.5", got)
+	}
+	if got := PowerLawExponent(bins[:1]); !math.IsNaN(got) {
+		t.Errorf("PowerLawExponent Test failed: got %v from a single bin, want NaN", got)
+	}
+}
+
+func TestParseIntRange(t *testing.T) {
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"5", []int{5}},
+		{"51,101, 201", []int{51, 101, 201}},
+		{"10:20:5,3", []int{10, 15, 20, 3}},
+		{"1:3", []int{1, 2, 3}},
+		{"1000:100000:x10", []int{1000, 10000, 100000}},
+		{"2:20:x3", []int{2, 6, 18}},
+		{"9223372036854775806:9223372036854775807", []int{9223372036854775806, 9223372036854775807}},
+		{"4611686018427387904:9223372036854775807:x2", []int{4611686018427387904}},
+		{"9223372036854775800:9223372036854775807:5", []int{9223372036854775800, 9223372036854775805}},
+	}
+	for _, test := range tests {
+		got, err := ParseIntRange(test.text)
+		if err != nil || len(got) != len(test.want) {
+			t.Errorf("ParseIntRange(%q) failed: got %v, %v, want %v", test.text, got, err, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("ParseIntRange(%q) failed: got %v, want %v", test.text, got, test.want)
+				break
+			}
+		}
+	}
+
+	// ranges too long to sweep are refused before they are built
+	for _, text := range []string{"", "a", "5:1", "1:5:0", "0:10:x2", "1:5:x1", "1:2:3:4", "1:9223372036854775807", "1:1000000000", "1:10000,5"} {
+		if _, err := ParseIntRange(text); err == nil {
+			t.Errorf("ParseIntRange(%q) failed: gave no error", text)
+		}
+	}
+}
+
+func TestRunBench(t *testing.T) {
+	config := BenchConfig{
+		Sizes:   []int{9, 15},
+		Coins:   []int{200, 600},
+		Workers: []int{1, 3},
+		Engines: []string{"serial", "parallel", "sparse"},
+		Trials:  2,
+		Lattice: VonNeumann{},
+		Init:    "central",
+	}
+	numProgress := 0
+	results, err := RunBench(config, func(BenchResult) { numProgress++ })
+	if err != nil {
+		t.Fatalf("RunBench failed: %v", err)
+	}
+	// serial and sparse run once per board, parallel once per worker count
+	if len(results) != 2*2*4 || numProgress != len(results) {
+		t.Fatalf("RunBench failed: %d results and %d progress calls, want 16", len(results), numProgress)
+	}
+
+	for i := 0; i < len(results); i += 4 {
+		board := results[i : i+4]
+		stable := centralBoard(board[0].Size, board[0].Size, board[0].Coins)
+		toppleSparse(stable, VonNeumann{}, nil)
+		lost := board[0].Coins - boardTotal(stable)
+
+		for _, r := range board {
+			if r.GrainsLost != lost {
+				t.Errorf("RunBench failed: %s on %d coins lost %d grains, want %d", r.Engine, r.Coins, r.GrainsLost, lost)
+			}
+			if r.Trials != 2 || r.MeanSeconds <= 0 || r.MinSeconds > r.MeanSeconds || r.Speedup <= 0 {
+				t.Errorf("RunBench failed: bad timings %+v", r)
+			}
+			if (r.Engine == "sparse") != (r.Sweeps == 0) {
+				t.Errorf("RunBench failed: %s took %d sweeps", r.Engine, r.Sweeps)
+			}
+		}
+		if board[0].Engine != "serial" || board[0].Speedup != 1 {
+			t.Errorf("RunBench failed: serial result %+v should come first with a speedup of 1", board[0])
+		}
+	}
+
+	dir := t.TempDir()
+	for _, name := range []string{"bench.csv", "bench.json"} {
+		if err := WriteBenchReport(results, filepath.Join(dir, name)); err != nil {
+			t.Errorf("WriteBenchReport %s failed: %v", name, err)
+		}
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, "bench.json"))
+	var decoded []BenchResult
+	if err := json.Unmarshal(data, &decoded); err != nil || len(decoded) != len(results) || decoded[5] != results[5] {
+		t.Errorf("WriteBenchReport failed: JSON does not hold the results (%v)", err)
+	}
+	file, _ := os.Open(filepath.Join(dir, "bench.csv"))
+	defer file.Close()
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil || len(records) != len(results)+1 || records[0][9] != "grains_lost" {
+		t.Errorf("WriteBenchReport failed: CSV has %d rows (%v)", len(records), err)
+	}
+	if err := WriteBenchReport(results, filepath.Join(dir, "bench.txt")); err == nil {
+		t.Errorf("WriteBenchReport failed: unknown extension gave no error")
+	}
+}
+
+func TestLiveView(t *testing.T) {
+	board := centralBoard(11, 11, 200)
+	sweeps := simulateEveryFrame(copyBoard(board), VonNeumann{})
+
+	view := NewLiveView(board, VonNeumann{}, DrawOptions{CellWidth: 3, Shape: "square"}, true)
+	done := make(chan error)
+	go func() { done <- view.Run() }()
+	server := httptest.NewServer(view.Handler())
+	defer server.Close()
+
+	post := func(path string) int {
+		resp, err := http.Post(server.URL+path, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	waitFor := func(what string, ready func(liveState) bool) liveState {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			resp, err := http.Get(server.URL + "/state")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var state liveState
+			err = json.NewDecoder(resp.Body).Decode(&state)
+			resp.Body.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ready(state) {
+				return state
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s", what)
+		return liveState{}
+	}
+	shown := func() Board {
+		view.mu.Lock()
+		defer view.mu.Unlock()
+		return copyBoard(view.board)
+	}
+
+	// Paused, the view holds the input board until it is stepped one sweep at a time
+	state := waitFor("the input board", func(s liveState) bool { return s.Frame == 1 })
+	if !state.Paused || state.Stable || state.Rows != 11 || state.Cols != 11 {
+		t.Fatalf("state of the paused view is %+v", state)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if !boardsEqual(shown(), sweeps[0]) {
+		t.Fatalf("paused view moved on from the input board")
+	}
+	for step := 1; step <= 2; step++ {
+		if code := post("/step"); code != http.StatusOK {
+			t.Fatalf("step returned status %d", code)
+		}
+		waitFor("a step", func(s liveState) bool { return s.Frame == step+1 })
+		if !boardsEqual(shown(), sweeps[step]) {
+			t.Fatalf("view after %d steps:\n%s\nexpected\n%s", step, boardToString(shown()), boardToString(sweeps[step]))
+		}
+	}
+
+	resp, err := http.Get(server.URL + "/frame.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := png.Decode(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frame.Bounds().Dx() != 33 || frame.Bounds().Dy() != 33 {
+		t.Fatalf("frame is %v, expected 33x33", frame.Bounds())
+	}
+
+	for _, path := range []string{"/drop?row=11&col=0", "/drop?row=0&col=0&grains=0", "/drop?row=a&col=0"} {
+		if code := post(path); code != http.StatusBadRequest {
+			t.Errorf("%s returned status %d, expected %d", path, code, http.StatusBadRequest)
+		}
+	}
+	if resp, err := http.Get(server.URL + "/pause"); err != nil || resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /pause was accepted")
+	}
+
+	// The sandpile is abelian, so the grains dropped mid-run end up where they would have from the start
+	if code := post("/drop?row=0&col=0&grains=50"); code != http.StatusOK {
+		t.Fatalf("drop returned status %d", code)
+	}
+	post("/resume")
+	waitFor("the stable board", func(s liveState) bool { return s.Stable && !s.Paused })
+	expected := centralBoard(11, 11, 200)
+	expected[0][0] = 50
+	if err := SimulateSandpiles(expected, VonNeumann{}, math.MaxInt, discardSink{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !boardsEqual(shown(), expected) {
+		t.Fatalf("stable view:\n%s\nexpected\n%s", boardToString(shown()), boardToString(expected))
+	}
+
+	// A drop on the stable board starts the simulation again
+	post("/drop?row=5&col=5&grains=40")
+	waitFor("the second stable board", func(s liveState) bool { return s.Stable })
+	expected[5][5] += 40
+	SimulateSandpiles(expected, VonNeumann{}, math.MaxInt, discardSink{}, nil)
+	if !boardsEqual(shown(), expected) {
+		t.Fatalf("view after a second drop:\n%s\nexpected\n%s", boardToString(shown()), boardToString(expected))
+	}
+
+	view.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+	if CheckLocalAddress("localhost:8080") != nil || CheckLocalAddress("[::1]:80") != nil || CheckLocalAddress(":8080") == nil {
+		t.Errorf("CheckLocalAddress accepts the wrong addresses")
+	}
+}
+
+func TestLiveViewLastSweepDrop(t *testing.T) {
+	// The empty board is stable, so the frames are the input, one sweep and the final board
+	view := NewLiveView(centralBoard(3, 3, 0), VonNeumann{}, DrawOptions{CellWidth: 3, Shape: "square"}, false)
+	view.Delay = 300 * time.Millisecond
+	done := make(chan error)
+	go func() { done <- view.Run() }()
+	server := httptest.NewServer(view.Handler())
+	defer server.Close()
+
+	frame := func() (int, bool) {
+		view.mu.Lock()
+		defer view.mu.Unlock()
+		return view.frame, view.stable
+	}
+	waitFor := func(what string, ready func(int, bool) bool) {
+		deadline := time.Now().Add(10 * time.Second)
+		for time.Now().Before(deadline) {
+			if ready(frame()) {
+				return
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		t.Fatalf("timed out waiting for %s", what)
+	}
+
+	// Dropped while the view waits after the last sweep, the grains are only added with the final board
+	waitFor("the last sweep", func(n int, stable bool) bool { return n == 2 })
+	resp, err := http.Post(server.URL+"/drop?row=1&col=1&grains=4", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	waitFor("the board to relax again", func(n int, stable bool) bool { return n > 3 && stable })
+
+	view.mu.Lock()
+	shown := copyBoard(view.board)
+	view.mu.Unlock()
+	if expected := (Board{{0, 1, 0}, {1, 0, 1}, {0, 1, 0}}); !boardsEqual(shown, expected) {
+		t.Errorf("view after a drop in the last sweep:\n%s\nexpected\n%s", boardToString(shown), boardToString(expected))
+	}
+
+	view.Stop()
+	if err := <-done; err != nil {
+		t.Fatalf("Run returned %v", err)
+	}
+}
+
 func readSimulateTests(directory string) []simulateSandpile {
 	inputFiles := readDirectory(filepath.Join(directory, "input"))
 	outputFiles := readDirectory(filepath.Join(directory, "output"))
@@ -135,10 +1412,22 @@ func TestToppleChunk(t *testing.T) {
 
 	for i, test := range tests {
 		board := copyBoard(test.board)
+		b := bandOfRows(board, test.i, test.j)
 		finished := make(chan bool, 1)
-		go toppleChunk(board, test.i, test.j, finished)
+		go toppleChunk(VonNeumann{}, b, len(board), finished)
 		<-finished
 
+		// Copies the band back and spills its ghost rows onto the rows just outside it
+		for r := b.start; r < b.end; r++ {
+			copy(board[r], b.cells[r-b.start+1])
+		}
+		if b.start > 0 {
+			addRow(board[b.start-1], b.cells[0])
+		}
+		if b.end < len(board) {
+			addRow(board[b.end], b.cells[len(b.cells)-1])
+		}
+
 		if !boardsEqual(board, test.result) {
 			t.Errorf("ToppleChunk Test %d failed:\nGot:\n%v\nWant:\n%v",
 				i, boardToString(board), boardToString(test.result))
@@ -278,3 +1567,85 @@ func readDirectory(dir string) []os.DirEntry {
 	}
 	return files
 }
+
+func simulateSerial(board Board, lattice Lattice) []Board {
+	frames := &FrameList{}
+	if err := SimulateSandpiles(board, lattice, 500, frames, nil); err != nil {
+		panic(err)
+	}
+	return frames.Boards
+}
+
+func simulateParallel(board Board, numProcs int, lattice Lattice) []Board {
+	frames := &FrameList{}
+	if err := SimulateSandpilesParallel(board, numProcs, lattice, 500, frames, nil); err != nil {
+		panic(err)
+	}
+	return frames.Boards
+}
+
+func simulateSparse(board Board, lattice Lattice) []Board {
+	frames := &FrameList{}
+	if err := SimulateSandpilesSparse(board, lattice, frames, nil); err != nil {
+		panic(err)
+	}
+	return frames.Boards
+}
+
+// simulateEveryFrame snapshots after every sweep, so frame i is the board after i sweeps
+func simulateEveryFrame(board Board, lattice Lattice) []Board {
+	frames := &FrameList{}
+	if err := SimulateSandpiles(board, lattice, 1, frames, nil); err != nil {
+		panic(err)
+	}
+	return frames.Boards
+}
+
+// The checkerboard helpers snapshot every 3 sweeps so the frame by frame comparisons see many frames
+func simulateCheckerboard(board Board, lattice Lattice) []Board {
+	frames := &FrameList{}
+	if err := SimulateSandpilesCheckerboard(board, lattice, 3, frames, nil); err != nil {
+		panic(err)
+	}
+	return frames.Boards
+}
+
+func simulateCheckerboardParallel(board Board, numProcs int, lattice Lattice) []Board {
+	frames := &FrameList{}
+	if err := SimulateSandpilesCheckerboardParallel(board, numProcs, lattice, 3, frames, nil); err != nil {
+		panic(err)
+	}
+	return frames.Boards
+}
+
+func bandOfRows(board Board, start, end int) band {
+	cells := make(Board, end-start+2)
+	for r := range cells {
+		cells[r] = make([]int, len(board[0]))
+	}
+	for r := start; r < end; r++ {
+		copy(cells[r-start+1], board[r])
+	}
+	return band{start: start, end: end, cells: cells}
+}
+
+func centralBoard(numRows, numCols, numCoins int) Board {
+	board := make(Board, numRows)
+	for i := range board {
+		board[i] = make([]int, numCols)
+	}
+	board[numRows/2][numCols/2] = numCoins
+	return board
+}
+
+func randomBoard(numRows, numCols, numCoins int, seed int64) Board {
+	board := make(Board, numRows)
+	for i := range board {
+		board[i] = make([]int, numCols)
+	}
+	rng := rand.New(rand.NewSource(seed))
+	for i := 0; i < numCoins; i++ {
+		board[rng.Intn(numRows)][rng.Intn(numCols)]++
+	}
+	return board
+}
@@ -0,0 +1,100 @@
+package main
+
+// Add takes two boards of the same size.
+// It returns a new board holding the stabilized cellwise sum of the two boards,
+// which is the group operation of the abelian sandpile group. Neither input is modified.
+func Add(a, b Board) Board {
+
+	if len(a) != len(b) || len(a) == 0 || len(a[0]) != len(b[0]) {
+		panic("Error: boards passed to Add must be non-empty and the same size.")
+	}
+
+	sum := copyBoard(a)
+	for r := range sum {
+		for c := range sum[r] {
+			sum[r][c] += b[r][c]
+		}
+	}
+	toppleSparse(sum, VonNeumann{}, nil)
+	return sum
+}
+
+// Identity takes the number of rows and columns of a board.
+// It returns the identity element of the sandpile group on that board, computed as
+// stab(m - stab(m)) where m is the board holding 6 coins in every cell.
+func Identity(numRows, numCols int) Board {
+
+	if numRows <= 0 || numCols <= 0 {
+		panic("Error: Identity needs a positive number of rows and columns.")
+	}
+
+	full := make(Board, numRows)
+	for r := range full {
+		full[r] = make([]int, numCols)
+		for c := range full[r] {
+			full[r][c] = 6
+		}
+	}
+
+	stable := copyBoard(full)
+	toppleSparse(stable, VonNeumann{}, nil)
+
+	for r := range full {
+		for c := range full[r] {
+			full[r][c] -= stable[r][c]
+		}
+	}
+	toppleSparse(full, VonNeumann{}, nil)
+	return full
+}
+
+// IsRecurrent takes a board.
+// It returns true if the board is a recurrent configuration, using Dhar's burning algorithm:
+// a stable board is recurrent exactly when adding one coin for every edge a cell shares with
+// the border and stabilizing gives back the same board.
+func IsRecurrent(b Board) bool {
+
+	if len(b) == 0 || len(b[0]) == 0 {
+		return false
+	}
+
+	// Recurrent configurations are always stable
+	for r := range b {
+		for c := range b[r] {
+			if b[r][c] < 0 || b[r][c] >= 4 {
+				return false
+			}
+		}
+	}
+
+	numRows := len(b)
+	numCols := len(b[0])
+	burnt := copyBoard(b)
+
+	for r := range burnt {
+		for c := range burnt[r] {
+			if r == 0 {
+				burnt[r][c]++
+			}
+			if r == numRows-1 {
+				burnt[r][c]++
+			}
+			if c == 0 {
+				burnt[r][c]++
+			}
+			if c == numCols-1 {
+				burnt[r][c]++
+			}
+		}
+	}
+	toppleSparse(burnt, VonNeumann{}, nil)
+
+	for r := range b {
+		for c := range b[r] {
+			if burnt[r][c] != b[r][c] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Lattice describes the topology a sandpile topples on: which cells receive a coin when
+// a cell topples, how many coins a cell needs before it topples, and what happens to coins
+// sent past the edge of the board.
+type Lattice interface {
+	// Threshold is the number of coins a cell needs to topple. A topple removes this many
+	// coins from the cell and sends one to each of its neighbours.
+	Threshold() int
+	// Neighbours returns the (row, col) offsets of the cells that receive a coin when the
+	// cell at (row, col) topples.
+	Neighbours(row, col int) [][2]int
+	// Boundary takes the position of a neighbour on a numRows x numCols board, which may lie
+	// off the board, and returns where its coin lands. ok is false if the coin is lost.
+	Boundary(row, col, numRows, numCols int) (r, c int, ok bool)
+}
+
+var (
+	vonNeumannOffsets = [][2]int{{-1, 0}, {1, 0}, {0, -1}, {0, 1}}
+	mooreOffsets      = [][2]int{{-1, -1}, {-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, -1}, {1, 0}, {1, 1}}
+	// Hexagonal cells are stored with odd rows shifted half a cell to the right
+	hexEvenOffsets = [][2]int{{-1, -1}, {-1, 0}, {0, -1}, {0, 1}, {1, -1}, {1, 0}}
+	hexOddOffsets  = [][2]int{{-1, 0}, {-1, 1}, {0, -1}, {0, 1}, {1, 0}, {1, 1}}
+)
+
+// VonNeumann is the classic square lattice: a cell with 4 coins sends one to each
+// cardinal neighbour, and coins sent off the board fall into the sink.
+type VonNeumann struct{}
+
+// Moore is the square lattice with diagonal neighbours: a cell with 8 coins sends one to
+// each of the 8 surrounding cells, and coins sent off the board fall into the sink.
+type Moore struct{}
+
+// Hexagonal is the triangular lattice drawn as offset rows of hexagons: a cell with 6 coins
+// sends one to each of its 6 neighbours, and coins sent off the board fall into the sink.
+type Hexagonal struct{}
+
+// Torus wraps another lattice around a periodic torus, so coins leaving one edge of the
+// board come back on the opposite edge. No coins are ever lost, so a board holding too many
+// coins never stabilizes. A hexagonal torus needs an even number of rows.
+type Torus struct {
+	Lattice
+}
+
+func (VonNeumann) Threshold() int { return 4 }
+
+func (VonNeumann) Neighbours(row, col int) [][2]int { return vonNeumannOffsets }
+
+func (VonNeumann) Boundary(row, col, numRows, numCols int) (int, int, bool) {
+	return sinkBoundary(row, col, numRows, numCols)
+}
+
+func (Moore) Threshold() int { return 8 }
+
+func (Moore) Neighbours(row, col int) [][2]int { return mooreOffsets }
+
+func (Moore) Boundary(row, col, numRows, numCols int) (int, int, bool) {
+	return sinkBoundary(row, col, numRows, numCols)
+}
+
+func (Hexagonal) Threshold() int { return 6 }
+
+func (Hexagonal) Neighbours(row, col int) [][2]int {
+	if row%2 == 0 {
+		return hexEvenOffsets
+	}
+	return hexOddOffsets
+}
+
+func (Hexagonal) Boundary(row, col, numRows, numCols int) (int, int, bool) {
+	return sinkBoundary(row, col, numRows, numCols)
+}
+
+func (Torus) Boundary(row, col, numRows, numCols int) (int, int, bool) {
+	return wrap(row, numRows), wrap(col, numCols), true
+}
+
+// Input: a position and the size of the board
+// Output: the same position and true if it is on the board, otherwise false as the coin falls off the edge
+func sinkBoundary(row, col, numRows, numCols int) (int, int, bool) {
+	if row < 0 || row >= numRows || col < 0 || col >= numCols {
+		return 0, 0, false
+	}
+	return row, col, true
+}
+
+// Input: an index and the length of the dimension it indexes
+// Output: the index wrapped periodically into [0, n)
+func wrap(i, n int) int {
+	i %= n
+	if i < 0 {
+		i += n
+	}
+	return i
+}
+
+// LatticeFromName takes the name of a lattice.
+// It returns the matching Lattice and true, or false if the name is unknown.
+func LatticeFromName(name string) (Lattice, bool) {
+	switch name {
+	case "vonneumann":
+		return VonNeumann{}, true
+	case "moore":
+		return Moore{}, true
+	case "hexagonal":
+		return Hexagonal{}, true
+	case "torus":
+		return Torus{VonNeumann{}}, true
+	case "torus-moore":
+		return Torus{Moore{}}, true
+	case "torus-hexagonal":
+		return Torus{Hexagonal{}}, true
+	}
+	return nil, false
+}
+
+// errNeverStable is returned by the engines once a board on a closed lattice has shown it never stabilizes.
+var errNeverStable = errors.New("the board never stabilizes: no coin can leave the closed lattice")
+
+// closedSweepLimit is the number of sweeps after which the serial engine gives up on a rule other than
+// abelian that has not stabilized a board on a closed lattice, as it has no exact test for those rules.
+const closedSweepLimit = 100000
+
+// Closed takes a lattice.
+// It returns true if no coin toppled on it is ever lost: a torus that no mask cuts into.
+func Closed(lattice Lattice) bool {
+	switch l := lattice.(type) {
+	case Torus:
+		return true
+	case Masked:
+		return Closed(l.Lattice) && l.Mask.AllActive()
+	}
+	return false
+}
+
+// CheckRows takes a number of rows and a lattice.
+// It returns an error if the lattice is a hexagonal torus, masked or not, and the number of rows is
+// odd, as the offset rows then don't line up where the top and bottom edges meet.
+func CheckRows(numRows int, lattice Lattice) error {
+
+	if masked, ok := lattice.(Masked); ok {
+		lattice = masked.Lattice
+	}
+	if torus, ok := lattice.(Torus); ok && numRows%2 == 1 {
+		if _, ok := torus.Lattice.(Hexagonal); ok {
+			return fmt.Errorf("a hexagonal torus needs an even number of rows, not %d", numRows)
+		}
+	}
+	return nil
+}
+
+// CheckCapacity takes a board, the lattice it topples on and the threshold of its toppling rule.
+// It returns an error if the board has a number of rows CheckRows refuses, or if the lattice is
+// closed and the board holds more coins than a stable board can, threshold-1 on every cell, as the
+// coins then topple around it forever.
+func CheckCapacity(b Board, lattice Lattice, threshold int) error {
+
+	if err := CheckRows(len(b), lattice); err != nil {
+		return err
+	}
+	if !Closed(lattice) || len(b) == 0 {
+		return nil
+	}
+	capacity := len(b) * len(b[0]) * (threshold - 1)
+	if total := boardTotal(b); total > capacity {
+		return fmt.Errorf("%d coins never stabilize on a closed %d x %d board, which holds at most %d", total, len(b), len(b[0]), capacity)
+	}
+	return nil
+}
+
+// Input: a board, the lattice it topples on and the odometer of the run, which may be nil
+// Output: the odometer to topple with, which is a new one if the lattice is closed and there was
+// none, and a copy of it as it is now to pass to checkNeverStable, or nil if the lattice is not closed
+func closedOdometer(b Board, lattice Lattice, odometer Board) (Board, Board) {
+
+	if !Closed(lattice) {
+		return odometer, nil
+	}
+	if odometer == nil {
+		odometer = NewOdometer(b)
+	}
+	return odometer, copyBoard(odometer)
+}
+
+// Input: the odometer of an abelian run and a copy of it from the start of the run, nil if the
+// lattice is not closed
+// Output: errNeverStable if every cell has toppled since the start. Without a sink, toppling goes on
+// forever once every cell has toppled (Björner, Lovász and Shor), so there is no point sweeping on.
+func checkNeverStable(odometer, start Board) error {
+
+	if start == nil {
+		return nil
+	}
+	for r := range odometer {
+		for c := range odometer[r] {
+			if odometer[r][c] == start[r][c] {
+				return nil
+			}
+		}
+	}
+	return errNeverStable
+}
@@ -2,100 +2,640 @@
 // Date: 11/04/25
 
 package main
- 
+
 import (
+	"flag"
 	"fmt"
-	"gifhelper"
 	"math/rand"
+	"net/http"
 	"os"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 )
 
+// checkpointEvery is the number of snapshots between checkpoints
+const checkpointEvery = 20
+
 func main() {
-	
-	if len(os.Args) != 5 {
-		fmt.Println("Usage: ./sandpile boardWidth numCoins placement cellWidth")
+
+	if len(os.Args) > 1 && os.Args[1] == "drive" {
+		runDrive(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "resume" {
+		runResume(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "serve" {
+		runServe(os.Args[2:])
 		return
 	}
 
-	boardWidth, err1 := strconv.Atoi(os.Args[1])
-	numCoins, err2 := strconv.Atoi(os.Args[2])
-	placement := os.Args[3]
-	cellWidth, err3 := strconv.Atoi(os.Args[4])
-	numProcs := runtime.NumCPU()
-	var filename string
+	flags := flag.NewFlagSet("sandpile", flag.ExitOnError)
+	boardWidth := flags.Int("width", 101, "width of the board in cells")
+	boardHeight := flags.Int("height", 0, "height of the board in cells, the width by default")
+	numCoins := flags.Int("coins", 10000, "number of coins to place on the board")
+	placement := flags.String("init", "central", "initial configuration: "+strings.Join(ConfigNames(), ", "))
+	numSites := flags.Int("sites", 100, "number of random sites for random-k-sites")
+	sourceList := flags.String("sources", "", "sources for point-sources as row,col;row,col;...")
+	inputFile := flags.String("file", "", "image for image-mask or board file (.csv, .txt or .png) for csv")
+	cellWidth := flags.Int("cell-width", 5, "width of each cell in pixels")
+	paletteName := flags.String("palette", "", "cell colours: "+strings.Join(PaletteNames(), ", ")+" or a list of hex colours #rrggbb,...; gray by default, extended-black-body for zhang")
+	shape := flags.String("shape", "circle", "shape of the cells: circle or square")
+	legend := flags.Bool("legend", false, "add a legend of the cell colours below each frame")
+	frameCounter := flags.Bool("frame-counter", false, "write the frame number in the corner of each frame")
+	latticeName := flags.String("lattice", "vonneumann", "vonneumann, moore, hexagonal, torus, torus-moore or torus-hexagonal")
+	ruleName := flags.String("rule", "abelian", "toppling rule: "+strings.Join(RuleNames, ", ")+"; all but abelian need the serial engine")
+	domain := flags.String("domain", "full", "cells taking part: full, disk, annulus or image")
+	innerFraction := flags.Float64("inner", 0.5, "inner radius of the annulus as a fraction of the outer one")
+	domainFile := flags.String("domain-file", "", "image whose dark pixels make up the domain for image")
+	format := flags.String("format", "gif", "output format: gif, png or board")
+	seed := flags.Int64("seed", 0, "seed for the random configurations, 0 picks one from the clock")
+	engine := flags.String("engine", "both", "serial, parallel, both, sparse, checkerboard-serial, checkerboard-parallel or checkerboard")
+	numProcs := flags.Int("procs", runtime.NumCPU(), "number of processors for the parallel engine")
+	snapshotEvery := flags.Int("snapshot-every", 500, "number of sweeps between snapshots")
+	output := flags.String("out", "", "base name of the output files, sandpiles_<init> by default")
+	saveFinal := flags.String("save-final", "", "also save each engine's stable board as csv, txt or png")
+	countTopples := flags.Bool("odometer", false, "count the topples of every cell and save them as CSV and a heatmap PNG")
+	flags.Parse(os.Args[1:])
 
-	if err1 != nil || err2 != nil || err3 != nil || boardWidth <= 0 || numCoins <= 0 || cellWidth <= 0 {
-		fmt.Println("Error: all inputs must be positive values")
+	if *boardHeight == 0 {
+		*boardHeight = *boardWidth
+	}
+	if *boardWidth <= 0 || *boardHeight <= 0 || *numCoins < 0 || *cellWidth <= 0 || *numProcs <= 0 || *snapshotEvery <= 0 {
+		fmt.Println("Error: width, height, cell-width, procs and snapshot-every must be positive and coins can't be negative")
+		return
+	}
+	if _, ok := LatticeFromName(*latticeName); !ok {
+		fmt.Println("Lattice must be vonneumann, moore, hexagonal, torus, torus-moore or torus-hexagonal")
+		return
+	}
+	if *shape != "circle" && *shape != "square" {
+		fmt.Println("Shape must be circle or square")
+		return
+	}
+	if _, err := PaletteFromName(*paletteName, 3); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if *format != "gif" && *format != "png" && *format != "board" {
+		fmt.Println("Output format must be gif, png or board")
+		return
+	}
+	if *saveFinal != "" && *saveFinal != "csv" && *saveFinal != "txt" && *saveFinal != "png" {
+		fmt.Println("Saved boards must be csv, txt or png")
 		return
 	}
 
+	var engines []string
+	switch *engine {
+	case "serial", "parallel", "sparse", "checkerboard-serial", "checkerboard-parallel":
+		engines = []string{*engine}
+	case "both":
+		engines = []string{"serial", "parallel"}
+	case "checkerboard":
+		// the two checkerboard engines write identical frames, so their outputs can be compared
+		engines = []string{"checkerboard-serial", "checkerboard-parallel"}
+	default:
+		fmt.Println("Engine must be serial, parallel, both, sparse, checkerboard-serial, checkerboard-parallel or checkerboard")
+		return
+	}
+	if _, err := RuleFromName(*ruleName, VonNeumann{}, 1, 1, 0); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if *ruleName != "abelian" && *engine != "serial" {
+		fmt.Println("Error: the", *ruleName, "rule only runs on the serial engine")
+		return
+	}
+
+	// Every run prints its seed so a random configuration can be made again
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Using seed %d\n", *seed)
+
+	sources, err := ParseSources(*sourceList)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	opts := ConfigOptions{
+		NumCoins: *numCoins,
+		Rng:      rand.New(rand.NewSource(*seed)),
+		NumSites: *numSites,
+		Sources:  sources,
+		File:     *inputFile,
+	}
+
 	//Initialize the main board
-	board := make(Board, boardWidth)
-	for i := range board {
-		board[i] = make([]int, boardWidth)
+	board, err := NewInitialBoard(*placement, *boardHeight, *boardWidth, opts)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	// The mask follows the board, whose size a csv configuration may have changed
+	mask, err := MaskFromName(*domain, len(board), len(board[0]), *innerFraction, *domainFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	mask.Apply(board)
+
+	// Every coin is one unit of energy for the zhang rule, which the board holds in smaller units
+	if *ruleName == "zhang" {
+		for r := range board {
+			for c := range board[r] {
+				board[r][c] *= zhangResolution
+			}
+		}
+	}
+
+	// A closed lattice keeps every coin, so a board holding more than it can stabilize would topple forever
+	lattice, _ := LatticeFromName(*latticeName)
+	if mask != nil {
+		lattice = Masked{Lattice: lattice, Mask: mask}
+	}
+	rule, err := RuleFromName(*ruleName, lattice, len(board), len(board[0]), *seed)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := CheckCapacity(board, lattice, rule.Threshold()); err != nil {
+		fmt.Println("Error:", err)
+		return
 	}
 
-	switch placement {
+	filename := *output
+	if filename == "" {
+		filename = "sandpiles_" + *placement
+	}
 
-	case "central":
-		
-		filename = "sandpiles_central"
-		center := boardWidth / 2
-		board[center][center] = numCoins
+	for _, name := range engines {
+		run := Checkpoint{
+			Engine:        name,
+			NumProcs:      *numProcs,
+			SnapshotEvery: *snapshotEvery,
+			Lattice:       *latticeName,
+			Rule:          *ruleName,
+			RuleSeed:      *seed,
+			Mask:          mask,
+			Format:        *format,
+			Output:        filename + "_" + name,
+			CellWidth:     *cellWidth,
+			Palette:       *paletteName,
+			Shape:         *shape,
+			Legend:        *legend,
+			FrameCounter:  *frameCounter,
+		}
+		if *countTopples {
+			run.Odometer = NewOdometer(board)
+		}
 
-	case "random":
+		// Simulation, streaming its snapshots straight to the output
+		if name == "parallel" || name == "checkerboard-parallel" {
+			fmt.Printf("Running %s sandpile simulation with %d cores\n", name, *numProcs)
+		} else {
+			fmt.Printf("Running %s sandpile simulation (%s placement)\n", name, *placement)
+		}
+		start := time.Now()
+		finalBoard := copyBoard(board)
+		if err := runEngine(run, finalBoard, false); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		elapsed := time.Since(start)
+		fmt.Printf("%s simulation and output complete in %s seconds.\n", strings.ToUpper(name[:1])+name[1:], elapsed)
 
-		filename = "sandpiles_random"
-		positions := make([][2]int, 100)
-		for i := 0; i < 100; i++ {
-			r := rand.Intn(boardWidth)
-			c := rand.Intn(boardWidth)
-			positions[i] = [2]int{r, c}
+		if *saveFinal != "" {
+			if err := WriteBoard(finalBoard, run.Output+"_final."+*saveFinal); err != nil {
+				fmt.Println("Error saving final board:", err)
+				return
+			}
+		}
+		if run.Odometer != nil {
+			if err := WriteOdometer(run.Odometer, run.Output, run.CellWidth, run.Mask); err != nil {
+				fmt.Println("Error saving odometer:", err)
+				return
+			}
 		}
+	}
+
+	fmt.Println("Output generated successfully")
+}
 
-		for i := 0; i < numCoins; i++ {
-			position := positions[rand.Intn(100)]
-			board[position[0]][position[1]]++
+// runEngine runs the engine named by run on the board, streaming snapshots into the run's output
+// and saving a checkpoint every checkpointEvery snapshots to the output name with a .ckpt extension.
+// When resuming, the board is the one from the checkpoint and its snapshot is not written again.
+// Topples are counted in run.Odometer if it is not nil.
+func runEngine(run Checkpoint, board Board, resuming bool) error {
+
+	lattice, ok := LatticeFromName(run.Lattice)
+	if !ok {
+		return fmt.Errorf("unknown lattice %q", run.Lattice)
+	}
+	if run.Mask != nil {
+		lattice = Masked{Lattice: lattice, Mask: run.Mask}
+	}
+
+	// A resumed run draws new random numbers rather than repeating those of the run it carries on
+	rule, err := RuleFromName(run.Rule, lattice, len(board), len(board[0]), run.RuleSeed+int64(run.FramesWritten))
+	if err != nil {
+		return err
+	}
+	// The checkpoints share the rotors of a rotor-router run so they always hold their latest positions
+	if rotors, ok := rule.(*RotorRouter); ok {
+		if run.Rotors != nil {
+			rotors.Rotors = run.Rotors
 		}
+		run.Rotors = rotors.Rotors
+	}
 
-	default:
-		fmt.Println("Must be central or random")
+	// continuous palettes span the stable values of the rule
+	paletteName := run.Palette
+	if paletteName == "" && run.Rule == "zhang" {
+		paletteName = "extended-black-body"
+	}
+	colors, err := PaletteFromName(paletteName, rule.Threshold()-1)
+	if err != nil {
+		return err
+	}
+	opts := DrawOptions{
+		CellWidth:    run.CellWidth,
+		Palette:      colors,
+		Shape:        run.Shape,
+		Mask:         run.Mask,
+		Legend:       run.Legend,
+		FrameCounter: run.FrameCounter,
+	}
+
+	output, err := NewFrameSink(run.Format, run.Output, opts, run.FramesWritten)
+	if err != nil {
+		return err
+	}
+	checkpoints := &CheckpointSink{Filename: run.Output + ".ckpt", Every: checkpointEvery, Run: run}
+
+	var sink FrameSink = MultiSink{output, checkpoints}
+	if resuming {
+		sink = &skipFirstFrame{FrameSink: sink}
+	}
+
+	if _, ok := rule.(Abelian); ok {
+		err = simulateEngine(run.Engine, board, run.NumProcs, lattice, run.SnapshotEvery, sink, run.Odometer)
+	} else if run.Engine == "serial" {
+		err = SimulateSandpilesRule(board, rule, run.SnapshotEvery, sink, run.Odometer)
+	} else {
+		err = fmt.Errorf("the %s rule only runs on the serial engine", run.Rule)
+	}
+
+	// A failed run keeps its last checkpoint so it can be resumed
+	if err != nil {
+		output.Close()
+		return err
+	}
+	return sink.Close()
+}
+
+// simulateEngine runs the engine with the given name (serial, parallel, sparse, checkerboard-serial or
+// checkerboard-parallel) on the board, passing on the arguments that engine takes.
+func simulateEngine(name string, board Board, numProcs int, lattice Lattice, snapshotEvery int, sink FrameSink, odometer Board) error {
+	switch name {
+	case "serial":
+		return SimulateSandpiles(board, lattice, snapshotEvery, sink, odometer)
+	case "parallel":
+		return SimulateSandpilesParallel(board, numProcs, lattice, snapshotEvery, sink, odometer)
+	case "sparse":
+		return SimulateSandpilesSparse(board, lattice, sink, odometer)
+	case "checkerboard-serial":
+		return SimulateSandpilesCheckerboard(board, lattice, snapshotEvery, sink, odometer)
+	case "checkerboard-parallel":
+		return SimulateSandpilesCheckerboardParallel(board, numProcs, lattice, snapshotEvery, sink, odometer)
+	}
+	return fmt.Errorf("unknown engine %q", name)
+}
+
+// runResume carries on an interrupted run from its checkpoint file.
+// Usage: ./sandpile resume checkpointFile
+func runResume(args []string) {
+
+	if len(args) != 1 {
+		fmt.Println("Usage: ./sandpile resume checkpointFile")
 		return
 	}
-	parallelBoard := copyBoard(board)
 
-	//Serial simulation
-	fmt.Printf("Running serial sandpile simulation (%s placement)\n", placement)
-	startSerial := time.Now()
-	timePoints := SimulateSandpiles(board)
-	elapsedSerial := time.Since(startSerial)
-	fmt.Printf("Serial simulation complete in %s seconds.\n", elapsedSerial)
+	checkpoint, err := ReadCheckpoint(args[0])
+	if err != nil {
+		fmt.Println("Error reading checkpoint:", err)
+		return
+	}
 
-	//Parallel simulation
-	fmt.Printf("Running parallel sandpile simulation with %d cores\n", numProcs)
-	startParallel := time.Now()
-	parallelTimePoints := SimulateSandpilesParallel(parallelBoard, numProcs)
-	elapsedParallel := time.Since(startParallel)
-	fmt.Printf("Parallel simulation complete in %s seconds.\n", elapsedParallel)
+	fmt.Printf("Resuming %s sandpile simulation after %d snapshots\n", checkpoint.Engine, checkpoint.FramesWritten)
+	start := time.Now()
+	if err := runEngine(checkpoint, checkpoint.Board, true); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Resumed simulation complete in %s seconds.\n", time.Since(start))
 
-	//Generate GIFs
-	fmt.Println("Drawing and generating GIFs")
+	if checkpoint.Odometer != nil {
+		if err := WriteOdometer(checkpoint.Odometer, checkpoint.Output, checkpoint.CellWidth, checkpoint.Mask); err != nil {
+			fmt.Println("Error saving odometer:", err)
+		}
+	}
+}
 
-	serialImages := AnimateBoards(timePoints, cellWidth)
-	startSerialGIF := time.Now()
-	gifhelper.ImagesToGIF(serialImages, filename+"_serial")
-	elapsedSerialGIF := time.Since(startSerialGIF)
-	fmt.Printf("Serial GIF complete in %s seconds.\n", elapsedSerialGIF)
+// runBench times the engines over ranges of board sizes, coin counts and worker counts and
+// writes the results to a CSV or JSON report.
+// Usage: ./sandpile bench [flags]
+func runBench(args []string) {
 
-	parallelImages := AnimateBoardsParallel(parallelTimePoints, cellWidth, numProcs)
-	startParallelGIF := time.Now()
-	gifhelper.ImagesToGIF(parallelImages, filename+"_parallel")
-	elapsedParallelGIF := time.Since(startParallelGIF)
-	fmt.Printf("Parallel GIF complete in %s seconds.\n", elapsedParallelGIF)
+	flags := flag.NewFlagSet("sandpile bench", flag.ExitOnError)
+	sizeList := flags.String("sizes", "51,101,201", "board widths as a list of numbers and start:end:step ranges")
+	coinList := flags.String("coins", "10000", "coin counts as a list of numbers and start:end:step or start:end:xfactor ranges")
+	workerList := flags.String("workers", strconv.Itoa(runtime.NumCPU()), "worker counts for the parallel engines")
+	engineList := flags.String("engines", "serial,parallel", "engines to compare: serial, parallel, sparse, checkerboard-serial, checkerboard-parallel")
+	trials := flags.Int("trials", 3, "timed runs of every combination")
+	placement := flags.String("init", "central", "initial configuration: "+strings.Join(ConfigNames(), ", "))
+	numSites := flags.Int("sites", 100, "number of random sites for random-k-sites")
+	latticeName := flags.String("lattice", "vonneumann", "vonneumann, moore, hexagonal, torus, torus-moore or torus-hexagonal")
+	seed := flags.Int64("seed", 1, "seed for the random configurations, the same for every board")
+	report := flags.String("report", "sandpiles_bench.csv", "report file, .csv or .json")
+	flags.Parse(args)
 
-	fmt.Println("GIFs generated successfully")
+	sizes, err := ParseIntRange(*sizeList)
+	if err != nil {
+		fmt.Println("Error in sizes:", err)
+		return
+	}
+	coins, err := ParseIntRange(*coinList)
+	if err != nil {
+		fmt.Println("Error in coins:", err)
+		return
+	}
+	workers, err := ParseIntRange(*workerList)
+	if err != nil {
+		fmt.Println("Error in workers:", err)
+		return
+	}
+	for _, size := range sizes {
+		if size <= 0 {
+			fmt.Println("Error: sizes must be positive")
+			return
+		}
+	}
+	for _, numCoins := range coins {
+		if numCoins < 0 {
+			fmt.Println("Error: coins can't be negative")
+			return
+		}
+	}
+	for _, numProcs := range workers {
+		if numProcs <= 0 {
+			fmt.Println("Error: workers must be positive")
+			return
+		}
+	}
+	engines := strings.Split(*engineList, ",")
+	for i, name := range engines {
+		engines[i] = strings.TrimSpace(name)
+		switch engines[i] {
+		case "serial", "parallel", "sparse", "checkerboard-serial", "checkerboard-parallel":
+		default:
+			fmt.Println("Engines must be serial, parallel, sparse, checkerboard-serial or checkerboard-parallel")
+			return
+		}
+	}
+	lattice, ok := LatticeFromName(*latticeName)
+	if !ok {
+		fmt.Println("Lattice must be vonneumann, moore, hexagonal, torus, torus-moore or torus-hexagonal")
+		return
+	}
+	for _, size := range sizes {
+		if err := CheckRows(size, lattice); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+	// A closed lattice keeps every coin, so no board may hold more than it can stabilize
+	if Closed(lattice) {
+		for _, size := range sizes {
+			for _, numCoins := range coins {
+				if capacity := size * size * (lattice.Threshold() - 1); numCoins > capacity {
+					fmt.Printf("Error: %d coins never stabilize on a %s %d x %d board, which holds at most %d\n", numCoins, *latticeName, size, size, capacity)
+					return
+				}
+			}
+		}
+	}
+
+	config := BenchConfig{
+		Sizes:   sizes,
+		Coins:   coins,
+		Workers: workers,
+		Engines: engines,
+		Trials:  *trials,
+		Lattice: lattice,
+		Init:    *placement,
+		Options: ConfigOptions{NumSites: *numSites},
+		Seed:    *seed,
+	}
+
+	results, err := RunBench(config, func(r BenchResult) {
+		fmt.Printf("%-21s %5dx%-5d %9d coins %3d workers: %.4fs mean, %d sweeps, %d grains lost\n",
+			r.Engine, r.Size, r.Size, r.Coins, r.Workers, r.MeanSeconds, r.Sweeps, r.GrainsLost)
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := WriteBenchReport(results, *report); err != nil {
+		fmt.Println("Error writing report:", err)
+		return
+	}
+	fmt.Printf("Bench report written to %s\n", *report)
 }
 
+// runServe relaxes a sandpile in the background and serves it to a browser on localhost, where it can
+// be paused, stepped one sweep at a time and resumed, and clicking a cell drops grains on it.
+// Usage: ./sandpile serve [flags]
+func runServe(args []string) {
+
+	flags := flag.NewFlagSet("sandpile serve", flag.ExitOnError)
+	boardWidth := flags.Int("width", 101, "width of the board in cells")
+	boardHeight := flags.Int("height", 0, "height of the board in cells, the width by default")
+	numCoins := flags.Int("coins", 10000, "number of coins to place on the board")
+	placement := flags.String("init", "central", "initial configuration: "+strings.Join(ConfigNames(), ", "))
+	numSites := flags.Int("sites", 100, "number of random sites for random-k-sites")
+	inputFile := flags.String("file", "", "image for image-mask or board file (.csv, .txt or .png) for csv")
+	cellWidth := flags.Int("cell-width", 5, "width of each cell in pixels")
+	paletteName := flags.String("palette", "", "cell colours: "+strings.Join(PaletteNames(), ", ")+" or a list of hex colours #rrggbb,...; gray by default")
+	shape := flags.String("shape", "square", "shape of the cells: circle or square")
+	legend := flags.Bool("legend", false, "add a legend of the cell colours below the board")
+	latticeName := flags.String("lattice", "vonneumann", "vonneumann, moore, hexagonal, torus, torus-moore or torus-hexagonal")
+	domain := flags.String("domain", "full", "cells taking part: full, disk, annulus or image")
+	innerFraction := flags.Float64("inner", 0.5, "inner radius of the annulus as a fraction of the outer one")
+	domainFile := flags.String("domain-file", "", "image whose dark pixels make up the domain for image")
+	seed := flags.Int64("seed", 0, "seed for the random configurations, 0 picks one from the clock")
+	addr := flags.String("addr", "localhost:8080", "address to serve the viewer on, which must be on localhost")
+	delay := flags.Duration("delay", 20*time.Millisecond, "time to wait after every sweep")
+	paused := flags.Bool("paused", false, "start paused")
+	flags.Parse(args)
+
+	if *boardHeight == 0 {
+		*boardHeight = *boardWidth
+	}
+	if *boardWidth <= 0 || *boardHeight <= 0 || *numCoins < 0 || *cellWidth <= 0 || *delay < 0 {
+		fmt.Println("Error: width, height and cell-width must be positive and coins and delay can't be negative")
+		return
+	}
+	if err := CheckLocalAddress(*addr); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	lattice, ok := LatticeFromName(*latticeName)
+	if !ok {
+		fmt.Println("Lattice must be vonneumann, moore, hexagonal, torus, torus-moore or torus-hexagonal")
+		return
+	}
+	if *shape != "circle" && *shape != "square" {
+		fmt.Println("Shape must be circle or square")
+		return
+	}
+	colors, err := PaletteFromName(*paletteName, lattice.Threshold()-1)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	fmt.Printf("Using seed %d\n", *seed)
+	opts := ConfigOptions{
+		NumCoins: *numCoins,
+		Rng:      rand.New(rand.NewSource(*seed)),
+		NumSites: *numSites,
+		File:     *inputFile,
+	}
+	board, err := NewInitialBoard(*placement, *boardHeight, *boardWidth, opts)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	mask, err := MaskFromName(*domain, len(board), len(board[0]), *innerFraction, *domainFile)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	mask.Apply(board)
+	if mask != nil {
+		lattice = Masked{Lattice: lattice, Mask: mask}
+	}
+	if err := CheckCapacity(board, lattice, lattice.Threshold()); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	view := NewLiveView(board, lattice, DrawOptions{CellWidth: *cellWidth, Palette: colors, Shape: *shape, Mask: mask, Legend: *legend}, *paused)
+	view.Delay = *delay
+	go func() {
+		if err := view.Run(); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+	}()
+
+	fmt.Printf("Serving the sandpile at http://%s/\n", *addr)
+	if err := http.ListenAndServe(*addr, view.Handler()); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// runDrive drops grains one at a time on an empty board and writes the statistics of the
+// avalanches they cause to CSV files.
+// Usage: ./sandpile drive [flags]
+func runDrive(args []string) {
+
+	flags := flag.NewFlagSet("sandpile drive", flag.ExitOnError)
+	boardWidth := flags.Int("width", 101, "width of the board in cells")
+	boardHeight := flags.Int("height", 0, "height of the board in cells, the width by default")
+	numGrains := flags.Int("grains", 100000, "number of grains to drop one at a time")
+	site := flags.String("site", "random", "where grains are dropped: random or row,col")
+	latticeName := flags.String("lattice", "vonneumann", "vonneumann, moore, hexagonal, torus, torus-moore or torus-hexagonal")
+	seed := flags.Int64("seed", 0, "seed for random sites, 0 picks one from the clock")
+	output := flags.String("out", "sandpiles", "base name of the output CSV files")
+	flags.Parse(args)
+
+	if *boardHeight == 0 {
+		*boardHeight = *boardWidth
+	}
+	if *boardWidth <= 0 || *boardHeight <= 0 || *numGrains <= 0 {
+		fmt.Println("Error: width, height and grains must be positive values")
+		return
+	}
+
+	var chooseSite SiteChooser
+	if *site == "random" {
+		if *seed == 0 {
+			*seed = time.Now().UnixNano()
+		}
+		fmt.Printf("Using seed %d\n", *seed)
+		chooseSite = RandomSites(rand.New(rand.NewSource(*seed)), *boardHeight, *boardWidth)
+	} else {
+		parts := strings.Split(*site, ",")
+		if len(parts) != 2 {
+			fmt.Println("Site must be random or row,col")
+			return
+		}
+		row, err1 := strconv.Atoi(parts[0])
+		col, err2 := strconv.Atoi(parts[1])
+		if err1 != nil || err2 != nil || row < 0 || row >= *boardHeight || col < 0 || col >= *boardWidth {
+			fmt.Println("Error: site must lie on the board")
+			return
+		}
+		chooseSite = FixedSite(row, col)
+	}
+
+	lattice, ok := LatticeFromName(*latticeName)
+	if !ok {
+		fmt.Println("Lattice must be vonneumann, moore, hexagonal, torus, torus-moore or torus-hexagonal")
+		return
+	}
+	if Closed(lattice) {
+		fmt.Println("Error: drive needs grains to fall off the board, so the lattice can't be a torus")
+		return
+	}
+
+	board := make(Board, *boardHeight)
+	for i := range board {
+		board[i] = make([]int, *boardWidth)
+	}
+
+	fmt.Printf("Dropping %d grains on a %dx%d board\n", *numGrains, *boardWidth, *boardHeight)
+	start := time.Now()
+	avalanches, err := DriveSandpile(board, lattice, *numGrains, chooseSite)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Driving complete in %s seconds.\n", time.Since(start))
+
+	avalancheFile := *output + "_avalanches.csv"
+	histogramFile := *output + "_avalanche_histograms.csv"
+	if err := WriteAvalanches(avalanches, avalancheFile); err != nil {
+		fmt.Println("Error writing avalanches:", err)
+		return
+	}
+	if err := WriteAvalancheHistograms(avalanches, 2, histogramFile); err != nil {
+		fmt.Println("Error writing histograms:", err)
+		return
+	}
+	fmt.Printf("Avalanche statistics written to %s and %s\n", avalancheFile, histogramFile)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"math"
+)
+
+// Mask marks the inactive cells of a board, which lie outside the simulated domain. An inactive
+// cell acts as a sink: coins toppled onto it are lost, just like coins toppled off the edge.
+// A nil Mask leaves every cell active.
+type Mask [][]bool
+
+// Masked restricts another lattice to the active cells of a mask.
+type Masked struct {
+	Lattice
+	Mask Mask
+}
+
+func (m Masked) Boundary(row, col, numRows, numCols int) (int, int, bool) {
+	r, c, ok := m.Lattice.Boundary(row, col, numRows, numCols)
+	if !ok || m.Mask.Inactive(r, c) {
+		return 0, 0, false
+	}
+	return r, c, true
+}
+
+// Inactive returns true if the cell at (row, col) is outside the domain.
+func (m Mask) Inactive(row, col int) bool {
+	return m != nil && m[row][col]
+}
+
+// AllActive returns true if no cell is outside the domain, which is always so for a nil Mask.
+func (m Mask) AllActive() bool {
+	for r := range m {
+		for _, inactive := range m[r] {
+			if inactive {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Apply removes every coin from the inactive cells of the board, so a board set up without the
+// mask in mind never topples a cell outside the domain.
+func (m Mask) Apply(b Board) {
+	if m == nil {
+		return
+	}
+	for r := range b {
+		for c := range b[r] {
+			if m[r][c] {
+				b[r][c] = 0
+			}
+		}
+	}
+}
+
+// DiskMask takes the size of a board.
+// It returns the mask whose active cells are those inside the largest disk centred on the board.
+func DiskMask(numRows, numCols int) Mask {
+	return AnnulusMask(numRows, numCols, 0)
+}
+
+// AnnulusMask takes the size of a board and the inner radius as a fraction of the outer one.
+// It returns the mask whose active cells lie between the two circles centred on the board,
+// the outer one being the largest that fits.
+func AnnulusMask(numRows, numCols int, innerFraction float64) Mask {
+
+	centerRow := float64(numRows-1) / 2
+	centerCol := float64(numCols-1) / 2
+	outer := math.Min(float64(numRows), float64(numCols)) / 2
+	inner := innerFraction * outer
+
+	mask := make(Mask, numRows)
+	for r := range mask {
+		mask[r] = make([]bool, numCols)
+		for c := range mask[r] {
+			distance := math.Hypot(float64(r)-centerRow, float64(c)-centerCol)
+			mask[r][c] = distance > outer || distance < inner
+		}
+	}
+	return mask
+}
+
+// ImageMask takes the name of an image file and the size of a board.
+// It returns the mask whose active cells are those where the image, scaled onto the board,
+// is darker than mid-gray, so a black shape drawn on white becomes the domain.
+func ImageMask(filename string, numRows, numCols int) (Mask, error) {
+
+	dark, err := readImageMask(filename, numRows, numCols)
+	if err != nil {
+		return nil, err
+	}
+
+	mask := make(Mask, numRows)
+	active := 0
+	for r := range mask {
+		mask[r] = make([]bool, numCols)
+		for c := range mask[r] {
+			mask[r][c] = !dark[r][c]
+			if dark[r][c] {
+				active++
+			}
+		}
+	}
+	if active == 0 {
+		return nil, errors.New("image " + filename + " has no dark pixels to use as the domain")
+	}
+	return mask, nil
+}
+
+// MaskFromName takes the name of a domain (full, disk, annulus or image), the size of the board,
+// the inner radius fraction for annulus and the image file for image.
+// It returns the matching mask, which is nil for the full board.
+func MaskFromName(name string, numRows, numCols int, innerFraction float64, filename string) (Mask, error) {
+	switch name {
+	case "full":
+		return nil, nil
+	case "disk":
+		return DiskMask(numRows, numCols), nil
+	case "annulus":
+		if innerFraction < 0 || innerFraction >= 1 {
+			return nil, errors.New("annulus inner fraction must be in [0, 1)")
+		}
+		return AnnulusMask(numRows, numCols, innerFraction), nil
+	case "image":
+		return ImageMask(filename, numRows, numCols)
+	}
+	return nil, errors.New("domain must be full, disk, annulus or image")
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"image"
+	"image/png"
+	"os"
+
+	"gonum.org/v1/plot/palette/moreland"
+)
+
+// The odometer of a run counts how many times every cell toppled on the way to the stable board.
+// It does not depend on the order of the topples, so every engine gives the same odometer, and the
+// final board is the initial board plus the discrete Laplacian of the odometer.
+
+// NewOdometer takes a board.
+// It returns an odometer of the same size with every count at zero, ready to pass to an engine.
+func NewOdometer(b Board) Board {
+
+	odometer := make(Board, len(b))
+	for r := range b {
+		odometer[r] = make([]int, len(b[r]))
+	}
+	return odometer
+}
+
+// DrawHeatmap is a Board method.
+// Input: an integer cellWidth and a Mask, which may be nil
+// Output: the image of the board as a heatmap of square cells, coloured from black for 0 through
+// red and yellow to white for the largest value on the board, with a legend below it.
+// It is meant for boards such as the odometer whose values have no fixed range.
+func (b Board) DrawHeatmap(cellWidth int, mask Mask) image.Image {
+
+	maxValue := 1
+	for r := range b {
+		for c := range b[r] {
+			if b[r][c] > maxValue {
+				maxValue = b[r][c]
+			}
+		}
+	}
+
+	opts := DrawOptions{
+		CellWidth: cellWidth,
+		Palette:   NewContinuousPalette(moreland.ExtendedBlackBody(), maxValue),
+		Shape:     "square",
+		Mask:      mask,
+		Legend:    true,
+	}
+	return b.DrawFrame(opts, 0)
+}
+
+// WriteOdometer takes an odometer, the base name of a run's output, a cell width in pixels and the
+// Mask of the board, which may be nil.
+// It writes the counts to output_odometer.csv and their heatmap to output_odometer.png.
+func WriteOdometer(odometer Board, output string, cellWidth int, mask Mask) error {
+
+	if err := WriteBoard(odometer, output+"_odometer.csv"); err != nil {
+		return err
+	}
+
+	file, err := os.Create(output + "_odometer.png")
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return png.Encode(file, odometer.DrawHeatmap(cellWidth, mask))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gonum.org/v1/plot/palette"
+	"gonum.org/v1/plot/palette/moreland"
+)
+
+// Palette gives the colour a cell holding a number of coins is drawn in.
+type Palette interface {
+	Color(val int) color.Color
+	// LegendMax is the largest value worth showing in a legend; larger values share its colour
+	// or, for GrayPalette, only grow redder.
+	LegendMax() int
+}
+
+// GrayPalette is the original scheme: four grays for the stable values 0 to 3 and ever stronger
+// reds for the unstable values above them.
+type GrayPalette struct{}
+
+// ColorTable is a user-defined palette. A cell holding val coins takes the colour at index val,
+// and cells holding more coins than the table covers take its last colour.
+type ColorTable []color.Color
+
+// ContinuousPalette spreads a continuous colour map, such as those of gonum's moreland package,
+// over the values 0 to Max. Values above Max take the colour of Max. Use NewContinuousPalette,
+// which sets the range of the map to match.
+type ContinuousPalette struct {
+	Map palette.ColorMap
+	Max int
+}
+
+// ClassicPalette is the four-colour scheme common in pictures of sandpiles, with the stable
+// values 0 to 3 in black, blue, yellow and red and every unstable value in white.
+var ClassicPalette = ColorTable{
+	color.RGBA{0, 0, 0, 255},
+	color.RGBA{40, 90, 220, 255},
+	color.RGBA{250, 210, 40, 255},
+	color.RGBA{210, 30, 30, 255},
+	color.RGBA{255, 255, 255, 255},
+}
+
+// colorMaps maps the name of every moreland colour map to its constructor.
+var colorMaps = map[string]func() palette.ColorMap{
+	"smooth-blue-red":      moreland.SmoothBlueRed,
+	"smooth-blue-tan":      moreland.SmoothBlueTan,
+	"smooth-green-purple":  moreland.SmoothGreenPurple,
+	"smooth-green-red":     moreland.SmoothGreenRed,
+	"smooth-purple-orange": moreland.SmoothPurpleOrange,
+	"kindlmann":            moreland.Kindlmann,
+	"extended-kindlmann":   moreland.ExtendedKindlmann,
+	"black-body":           moreland.BlackBody,
+	"extended-black-body":  moreland.ExtendedBlackBody,
+}
+
+func (GrayPalette) Color(val int) color.Color {
+	switch {
+	case val <= 0:
+		return color.RGBA{30, 30, 30, 255}
+	case val == 1:
+		return color.RGBA{95, 95, 95, 255}
+	case val == 2:
+		return color.RGBA{190, 190, 190, 255}
+	case val == 3:
+		return color.RGBA{255, 255, 255, 255}
+	}
+	return color.RGBA{255, uint8(255 - math.Min(255, 40*math.Log2(float64(val-3)))), uint8(255 - math.Min(255, 80*math.Log2(float64(val-3)))), 255}
+}
+
+func (GrayPalette) LegendMax() int {
+	return 3
+}
+
+func (t ColorTable) Color(val int) color.Color {
+	if val < 0 {
+		val = 0
+	}
+	if val >= len(t) {
+		val = len(t) - 1
+	}
+	return t[val]
+}
+
+func (t ColorTable) LegendMax() int {
+	return len(t) - 1
+}
+
+func (p ContinuousPalette) Color(val int) color.Color {
+	if val < 0 {
+		val = 0
+	}
+	if val > p.Max {
+		val = p.Max
+	}
+	col, err := p.Map.At(float64(val))
+	if err != nil {
+		panic("Error: colour map failed at " + strconv.Itoa(val) + ": " + err.Error())
+	}
+	return col
+}
+
+func (p ContinuousPalette) LegendMax() int {
+	return p.Max
+}
+
+// NewContinuousPalette takes a colour map and the largest value it should span.
+// It returns the palette spreading the map over 0 to maxValue. The map is not changed again
+// afterwards, so the palette can be shared by goroutines drawing in parallel.
+func NewContinuousPalette(colorMap palette.ColorMap, maxValue int) ContinuousPalette {
+	colorMap.SetMin(0)
+	colorMap.SetMax(float64(maxValue))
+	return ContinuousPalette{Map: colorMap, Max: maxValue}
+}
+
+// PaletteNames returns the names understood by PaletteFromName in alphabetical order.
+func PaletteNames() []string {
+	names := []string{"gray", "classic"}
+	for name := range colorMaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PaletteFromName takes the name of a palette and the largest value a continuous map should span,
+// usually the largest stable value of the lattice.
+// The name is gray, classic, one of the moreland maps listed by PaletteNames, or a user-defined
+// colour table written as a comma separated list of hex colours such as #000000,#ff0000,#ffffff.
+func PaletteFromName(name string, maxValue int) (Palette, error) {
+
+	switch name {
+	case "", "gray":
+		return GrayPalette{}, nil
+	case "classic":
+		return ClassicPalette, nil
+	}
+	if newMap, ok := colorMaps[name]; ok {
+		if maxValue <= 0 {
+			return nil, errors.New("a continuous palette needs a positive largest value")
+		}
+		return NewContinuousPalette(newMap(), maxValue), nil
+	}
+	if strings.HasPrefix(name, "#") {
+		return ParseColorTable(name)
+	}
+	return nil, fmt.Errorf("unknown palette %q, must be a list of hex colours or one of %s", name, strings.Join(PaletteNames(), ", "))
+}
+
+// ParseColorTable takes a comma separated list of colours written as #rrggbb.
+// It returns the colour table holding them in order.
+func ParseColorTable(text string) (ColorTable, error) {
+
+	table := make(ColorTable, 0)
+	for _, part := range strings.Split(text, ",") {
+		hex := strings.TrimPrefix(strings.TrimSpace(part), "#")
+		if len(hex) != 6 {
+			return nil, fmt.Errorf("colour %q must be written as #rrggbb", part)
+		}
+		val, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("colour %q must be written as #rrggbb", part)
+		}
+		table = append(table, color.RGBA{uint8(val >> 16), uint8(val >> 8), uint8(val), 255})
+	}
+	return table, nil
+}
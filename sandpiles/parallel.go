@@ -4,70 +4,208 @@
 
 package main
 
-// SimulateSandpilesParallel takes as input a Board object and the number of processors.
-// It returns a slice of Board objects, corresponding to repeated topples of the input
-// board until we reach stability.
-func SimulateSandpilesParallel(currentBoard Board, numProcs int) []Board {
+// SimulateSandpilesParallel takes as input a Board object, the number of processors, the
+// lattice it topples on, the number of sweeps between snapshots, a FrameSink and an odometer
+// board, which may be nil.
+// It topples the board in place until we reach stability, passing the input board, every
+// snapshotEvery-th sweep and the final stable board to the sink as it goes.
+// Every processor owns a private band of rows plus a ghost row above and below it, so no
+// two goroutines ever write to the same memory. Grains spilled into the ghost rows are
+// handed to the neighbouring band once every processor has finished its sweep. Each processor
+// counts the topples of its own rows straight into the odometer, if there is one.
+func SimulateSandpilesParallel(currentBoard Board, numProcs int, lattice Lattice, snapshotEvery int, sink FrameSink, odometer Board) error {
 
-	finalBoards := make([]Board, 0)
-	finalBoards = append(finalBoards, copyBoard(currentBoard))
-	numRows := len(currentBoard)
-	finished := make(chan bool, numProcs)
+	if snapshotEvery <= 0 {
+		return errSnapshotEvery
+	}
+	if err := sink.AddFrame(currentBoard); err != nil {
+		return err
+	}
+	odometer, start := closedOdometer(currentBoard, lattice, odometer)
+	bands := splitBands(currentBoard, numProcs, odometer)
+	finished := make(chan bool, len(bands))
 	interval := 0
 
 	for {
-		// Creates chunks to pass through a channel by divding rows by processors in your computer 
-		for i := 0; i < numProcs; i++ {
-			chunkSize := numRows / numProcs
-			startIndex := i * chunkSize
-			endIndex := startIndex + chunkSize
-
-			if i == numProcs-1 {
-				endIndex = numRows
-			}
-			// Passes the chunk of one processor into a function with a start and end index of the row to topple 
-			go toppleChunk(currentBoard, startIndex, endIndex, finished)
+		// Each processor topples the real rows of its own band, skipping the two ghost rows
+		for _, b := range bands {
+			go toppleChunk(lattice, b, len(currentBoard), finished)
 		}
-		// Indicates the channel and its processor is finished 
-		for i := 0; i < numProcs; i++ {
+		// Waiting on every processor acts as the barrier between sweeps
+		for range bands {
 			<-finished
 		}
-		
+
+		exchangeGhostRows(bands)
 		interval++
-		stable := true
-		// Loops over each value in the board and checks if a topple needs to occur 
-		// After toppling, board is possibly unstable so sets stable to false so that it runs one more iteration 
-		for r := range currentBoard {
-			for c := range currentBoard[r] {
-				if currentBoard[r][c] >= 4 {
-					stable = false
-					break
-				}
+		stable := bandsStable(bands, lattice.Threshold())
+
+		// Only passes every snapshotEvery-th iteration of the board to save memory when making the gif
+		if interval%snapshotEvery == 0 {
+			joinBands(currentBoard, bands)
+			if err := sink.AddFrame(currentBoard); err != nil {
+				return err
 			}
 		}
-		// Only passes every 500th iteration of the board to save memory when making the gif
-		if interval%500 == 0 {
-			finalBoards = append(finalBoards, copyBoard(currentBoard))
-		}
-		// Breaks out of the loop once the board is fully stable 
+		// Breaks out of the loop once the board is fully stable
 		if stable {
 			break
 		}
+		// Every processor has finished the sweep, so the odometer can be read
+		if err := checkNeverStable(odometer, start); err != nil {
+			return err
+		}
 	}
-	finalBoards = append(finalBoards,copyBoard(currentBoard))
-	return finalBoards
+	joinBands(currentBoard, bands)
+	return sink.AddFrame(currentBoard)
 }
 
-// Input: a board, the start and end index of the row we want to topple and a channel to indicate the process has finished
-// Output: a board after all the topples are complete for the chunk passed through from its processor 
-func toppleChunk(board Board, start, end int, finished chan bool) {
+// Input: a lattice, a band, the number of rows in the full board and a channel to indicate the process has finished
+// Output: the band after all the topples are complete for its real rows; coins toppled onto a row
+// owned by another band are collected in the ghost row on that side
+func toppleChunk(lattice Lattice, b band, numRows int, finished chan bool) {
 
-	for r := start; r < end; r++ {
-		for c := range board[r] {
-			if board[r][c] >= 4 {
-				board.Topple(r, c)
+	threshold := lattice.Threshold()
+
+	for row := b.start; row < b.end; row++ {
+		cells := b.cells[row-b.start+1]
+		for col := range cells {
+			if cells[col] >= threshold {
+				toppleInBand(lattice, b, numRows, row, col)
 			}
 		}
 	}
 	finished <- true
 }
+
+// Input: a lattice, a band, the number of rows in the full board and the position of a cell in the
+// full board that lies in one of the band's real rows
+// Output: the band with the threshold taken from the cell and one coin sent to each of its neighbours;
+// coins toppled onto a row owned by another band are collected in the ghost row on that side
+func toppleInBand(lattice Lattice, b band, numRows, row, col int) {
+
+	numCols := len(b.cells[0])
+	b.cells[row-b.start+1][col] -= lattice.Threshold()
+	if b.odometer != nil {
+		b.odometer[row-b.start][col]++
+	}
+
+	// Offsets are taken from the position in the full board so lattices whose
+	// neighbours depend on the row (hexagonal) see the right row
+	for _, offset := range lattice.Neighbours(row, col) {
+		r, c, ok := lattice.Boundary(row+offset[0], col+offset[1], numRows, numCols)
+		if !ok {
+			continue
+		}
+		switch {
+		case r >= b.start && r < b.end:
+			b.cells[r-b.start+1][c]++
+		case offset[0] < 0:
+			b.cells[0][c]++
+		default:
+			b.cells[len(b.cells)-1][c]++
+		}
+	}
+}
+
+// Input: a board, the number of processors and an odometer board, which may be nil
+// Output: the board divided into row bands, one per processor, each holding a private copy of its rows
+// between an empty ghost row above and below, and the same rows of the odometer
+func splitBands(currentBoard Board, numProcs int, odometer Board) []band {
+
+	numRows := len(currentBoard)
+	// Never hand out more bands than there are rows
+	if numProcs > numRows {
+		numProcs = numRows
+	}
+	if numProcs < 1 {
+		numProcs = 1
+	}
+
+	bands := make([]band, numProcs)
+	chunkSize := numRows / numProcs
+
+	for i := range bands {
+		startIndex := i * chunkSize
+		endIndex := startIndex + chunkSize
+		if i == numProcs-1 {
+			endIndex = numRows
+		}
+
+		cells := make(Board, endIndex-startIndex+2)
+		for r := range cells {
+			cells[r] = make([]int, len(currentBoard[0]))
+		}
+		for r := startIndex; r < endIndex; r++ {
+			copy(cells[r-startIndex+1], currentBoard[r])
+		}
+		bands[i] = band{start: startIndex, end: endIndex, cells: cells}
+		// bands never share a row, so each can write to its rows of the odometer directly
+		if odometer != nil {
+			bands[i].odometer = odometer[startIndex:endIndex]
+		}
+	}
+	return bands
+}
+
+// Input: the bands after every processor has finished a sweep
+// Output: the grains in each ghost row added to the first or last real row of the neighbouring band,
+// with the ghost rows emptied again. The first and last bands are neighbours of each other, which only
+// matters on a torus since every other lattice drops grains spilled past the top or bottom of the board
+func exchangeGhostRows(bands []band) {
+
+	numBands := len(bands)
+	for i := range bands {
+		top := bands[i].cells[0]
+		bottom := bands[i].cells[len(bands[i].cells)-1]
+
+		above := bands[(i-1+numBands)%numBands].cells
+		addRow(above[len(above)-2], top)
+		addRow(bands[(i+1)%numBands].cells[1], bottom)
+		clearRow(top)
+		clearRow(bottom)
+	}
+}
+
+// Input: the bands of the board and the lattice's toppling threshold
+// Output: true if no real row of any band still holds a cell with threshold or more coins
+func bandsStable(bands []band, threshold int) bool {
+
+	for _, b := range bands {
+		for r := 1; r < len(b.cells)-1; r++ {
+			for _, val := range b.cells[r] {
+				if val >= threshold {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// Input: a board and the bands it was split into
+// Output: the board with its rows overwritten by the real rows of each band
+func joinBands(currentBoard Board, bands []band) {
+
+	for _, b := range bands {
+		for r := b.start; r < b.end; r++ {
+			copy(currentBoard[r], b.cells[r-b.start+1])
+		}
+	}
+}
+
+// Input: two rows of the same length
+// Output: the first row with every value of the second row added to it
+func addRow(dst, src []int) {
+	for c := range src {
+		dst[c] += src[c]
+	}
+}
+
+// Input: a row
+// Output: the row with every value set to zero
+func clearRow(row []int) {
+	for c := range row {
+		row[c] = 0
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"errors"
+	"math/rand"
+)
+
+// ToppleRule decides how a cell topples. A cell holding Threshold() or more coins is unstable, and
+// Topple topples it once. Rules that keep state of their own, such as rotor positions or a random
+// number generator, belong to a single board and a single run.
+type ToppleRule interface {
+	Threshold() int
+	Topple(b Board, row, col int)
+}
+
+// Abelian is the classic rule on a lattice: a toppling cell sends one coin to each of its neighbours.
+// The final board does not depend on the order cells topple in.
+type Abelian struct {
+	Lattice
+}
+
+// RotorRouter is rotor-router aggregation. Every cell keeps one chip and sends any other chips on one
+// at a time: each visit turns the cell's rotor to its next neighbour and sends a single chip that way.
+// A pile of chips on one cell grows into an almost perfect disk.
+type RotorRouter struct {
+	Lattice Lattice
+	Rotors  Board // index into the cell's neighbours of the direction its last chip went
+}
+
+// Manna is the stochastic sandpile of Manna: a cell holding Critical grains or more sends Critical of
+// them away, each to a neighbour picked at random with Rng.
+type Manna struct {
+	Lattice  Lattice
+	Critical int
+	Rng      *rand.Rand
+}
+
+// Zhang is the continuous-height sandpile of Zhang. The board holds energies in units of 1/Resolution,
+// and a cell reaching the critical energy of 1 gives all of it away, split equally between its
+// neighbours. Whatever is too small to split stays behind, so no energy is lost to rounding.
+type Zhang struct {
+	Lattice    Lattice
+	Resolution int
+}
+
+// zhangResolution is the number of board units in one unit of energy for the zhang rule.
+const zhangResolution = 1000
+
+// RuleNames lists the names understood by RuleFromName.
+var RuleNames = []string{"abelian", "rotor-router", "manna", "zhang"}
+
+// NewRotorRouter takes a lattice and the size of the board.
+// It returns a RotorRouter whose rotors all start on their first neighbour.
+func NewRotorRouter(lattice Lattice, numRows, numCols int) *RotorRouter {
+
+	rotors := make(Board, numRows)
+	for r := range rotors {
+		rotors[r] = make([]int, numCols)
+	}
+	return &RotorRouter{Lattice: lattice, Rotors: rotors}
+}
+
+func (a Abelian) Topple(b Board, row, col int) {
+	b.ToppleOn(a.Lattice, row, col)
+}
+
+func (rr *RotorRouter) Threshold() int { return 2 }
+
+func (rr *RotorRouter) Topple(b Board, row, col int) {
+
+	neighbours := rr.Lattice.Neighbours(row, col)
+	rr.Rotors[row][col] = (rr.Rotors[row][col] + 1) % len(neighbours)
+	offset := neighbours[rr.Rotors[row][col]]
+
+	b[row][col]--
+	r, c, ok := rr.Lattice.Boundary(row+offset[0], col+offset[1], len(b), len(b[0]))
+	if ok {
+		b[r][c]++
+	}
+}
+
+func (m *Manna) Threshold() int { return m.Critical }
+
+func (m *Manna) Topple(b Board, row, col int) {
+
+	neighbours := m.Lattice.Neighbours(row, col)
+	b[row][col] -= m.Critical
+	for i := 0; i < m.Critical; i++ {
+		offset := neighbours[m.Rng.Intn(len(neighbours))]
+		r, c, ok := m.Lattice.Boundary(row+offset[0], col+offset[1], len(b), len(b[0]))
+		if ok {
+			b[r][c]++
+		}
+	}
+}
+
+func (z Zhang) Threshold() int { return z.Resolution }
+
+func (z Zhang) Topple(b Board, row, col int) {
+
+	neighbours := z.Lattice.Neighbours(row, col)
+	share := b[row][col] / len(neighbours)
+	b[row][col] -= share * len(neighbours)
+	for _, offset := range neighbours {
+		r, c, ok := z.Lattice.Boundary(row+offset[0], col+offset[1], len(b), len(b[0]))
+		if ok {
+			b[r][c] += share
+		}
+	}
+}
+
+// Input: a ToppleRule
+// Output: the lattice the rule topples on, or nil if it is not one of the rules here
+func ruleLattice(rule ToppleRule) Lattice {
+	switch r := rule.(type) {
+	case Abelian:
+		return r.Lattice
+	case *RotorRouter:
+		return r.Lattice
+	case *Manna:
+		return r.Lattice
+	case Zhang:
+		return r.Lattice
+	}
+	return nil
+}
+
+// RuleFromName takes the name of a rule, the lattice it topples on, the size of the board and a seed
+// for the rules that use random numbers.
+// It returns the matching ToppleRule. manna sends pairs of grains, as in Manna's original model.
+func RuleFromName(name string, lattice Lattice, numRows, numCols int, seed int64) (ToppleRule, error) {
+	switch name {
+	case "", "abelian":
+		return Abelian{lattice}, nil
+	case "rotor-router":
+		return NewRotorRouter(lattice, numRows, numCols), nil
+	case "manna":
+		return &Manna{Lattice: lattice, Critical: 2, Rng: rand.New(rand.NewSource(seed))}, nil
+	case "zhang":
+		return Zhang{Lattice: lattice, Resolution: zhangResolution}, nil
+	}
+	return nil, errors.New("rule must be abelian, rotor-router, manna or zhang")
+}
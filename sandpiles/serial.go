@@ -3,11 +3,46 @@
 
 package main
 
-func SimulateSandpiles(currentBoard Board) []Board {
+import (
+	"errors"
+	"fmt"
+)
 
-	finalBoards := make([]Board, 0)
-	finalBoards = append(finalBoards, copyBoard(currentBoard))
+// errSnapshotEvery is returned by the sweeping engines when they are not given a positive number
+// of sweeps between snapshots.
+var errSnapshotEvery = errors.New("the number of sweeps between snapshots must be positive")
+
+// SimulateSandpiles takes as input a Board object, the lattice it topples on, the number of sweeps
+// between snapshots, a FrameSink and an odometer board, which may be nil.
+// It topples the board in place with repeated sweeps until we reach stability, passing the input
+// board, every snapshotEvery-th sweep and the final stable board to the sink as it goes.
+// Every topple of a cell adds one to the same cell of the odometer, if there is one.
+// It stops early with the sink's error if the sink fails.
+func SimulateSandpiles(currentBoard Board, lattice Lattice, snapshotEvery int, sink FrameSink, odometer Board) error {
+	return SimulateSandpilesRule(currentBoard, Abelian{lattice}, snapshotEvery, sink, odometer)
+}
+
+// SimulateSandpilesRule runs the same sweeps as SimulateSandpiles, but every unstable cell is
+// toppled by the given ToppleRule, so any rule can use the serial loop and its snapshots.
+func SimulateSandpilesRule(currentBoard Board, rule ToppleRule, snapshotEvery int, sink FrameSink, odometer Board) error {
+
+	if snapshotEvery <= 0 {
+		return errSnapshotEvery
+	}
+	if err := sink.AddFrame(currentBoard); err != nil {
+		return err
+	}
+	threshold := rule.Threshold()
 	interval := 0
+	// A board on a closed lattice may never stabilize: the odometer shows it for abelian toppling,
+	// and every other rule is given up on after closedSweepLimit sweeps
+	var start Board
+	sweepLimit := 0
+	if abelian, ok := rule.(Abelian); ok {
+		odometer, start = closedOdometer(currentBoard, abelian.Lattice, odometer)
+	} else if Closed(ruleLattice(rule)) {
+		sweepLimit = closedSweepLimit
+	}
 	// Loops over each value in the board and checks if a topple needs to occur
 	// After toppling, board is possibly unstable so sets stable to false so that it runs one more iteration
 	for {
@@ -15,22 +50,32 @@ func SimulateSandpiles(currentBoard Board) []Board {
 		stable := true
 		for r := range currentBoard {
 			for c := range currentBoard[r] {
-				if currentBoard[r][c] >= 4 {
-					currentBoard.Topple(r, c)
+				if currentBoard[r][c] >= threshold {
+					rule.Topple(currentBoard, r, c)
+					if odometer != nil {
+						odometer[r][c]++
+					}
 					stable = false
 				}
 			}
 		}
 		interval++
-		// Only passes every 500th iteration of the board to save memory when making the gif
-		if interval%500 == 0 {
-			finalBoards = append(finalBoards, copyBoard(currentBoard))
+		// Only passes every snapshotEvery-th iteration of the board to save memory when making the gif
+		if interval%snapshotEvery == 0 {
+			if err := sink.AddFrame(currentBoard); err != nil {
+				return err
+			}
 		}
 		// Breaks out the loop once board is fully stable
 		if stable {
 			break
 		}
+		if err := checkNeverStable(odometer, start); err != nil {
+			return err
+		}
+		if interval == sweepLimit {
+			return fmt.Errorf("the board is still unstable after %d sweeps on a closed lattice", sweepLimit)
+		}
 	}
-	finalBoards = append(finalBoards,copyBoard(currentBoard))
-	return finalBoards
+	return sink.AddFrame(currentBoard)
 }
@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// LiveView runs a sandpile in the background and serves it to a browser as it relaxes. It is the
+// FrameSink of a serial simulation that snapshots every sweep, so between two sweeps it can publish
+// the board, hold the simulation while the viewer is paused and drop the grains the viewer clicked.
+// Once the board is stable it waits for more grains and starts the simulation again.
+type LiveView struct {
+	Lattice Lattice
+	Opts    DrawOptions
+	Delay   time.Duration // wait after every sweep so the relaxation can be watched
+
+	mu      sync.Mutex
+	changed *sync.Cond
+	live    Board // the board the simulation topples
+	board   Board // copy of the latest frame, which the viewer is served
+	frame   int
+	paused  bool
+	steps   int // sweeps still allowed while paused
+	drops   []grainDrop
+	stable  bool
+	stopped bool
+}
+
+// grainDrop is a number of grains the viewer dropped on a cell.
+type grainDrop struct {
+	row, col, grains int
+}
+
+// liveState is what the viewer is told about the simulation.
+type liveState struct {
+	Frame     int  `json:"frame"`
+	Rows      int  `json:"rows"`
+	Cols      int  `json:"cols"`
+	CellWidth int  `json:"cellWidth"`
+	Paused    bool `json:"paused"`
+	Stable    bool `json:"stable"`
+}
+
+// errStopped ends the simulation of a LiveView once Stop is called.
+var errStopped = errors.New("live view stopped")
+
+// NewLiveView takes the board to simulate, its lattice, the options to draw it with and whether
+// to start paused. The board is toppled in place once Run is called.
+func NewLiveView(board Board, lattice Lattice, opts DrawOptions, paused bool) *LiveView {
+
+	v := &LiveView{Lattice: lattice, Opts: opts, live: board, board: copyBoard(board), paused: paused}
+	v.changed = sync.NewCond(&v.mu)
+	v.stable = boardStable(board, lattice.Threshold())
+	return v
+}
+
+// Run simulates the board until Stop is called, relaxing it again whenever grains are dropped.
+func (v *LiveView) Run() error {
+
+	for {
+		err := SimulateSandpiles(v.live, v.Lattice, 1, v, nil)
+		if err == errStopped {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Grains dropped during the last sweep are only added once the simulation has found the board
+		// stable, so it is relaxed again straight away. Otherwise nothing happens until the viewer drops a grain
+		v.mu.Lock()
+		for !v.stopped && len(v.drops) == 0 && boardStable(v.live, v.Lattice.Threshold()) {
+			v.changed.Wait()
+		}
+		if v.stopped {
+			v.mu.Unlock()
+			return nil
+		}
+		v.publish(v.live)
+		v.mu.Unlock()
+	}
+}
+
+// Stop ends Run after the current sweep.
+func (v *LiveView) Stop() {
+	v.mu.Lock()
+	v.stopped = true
+	v.mu.Unlock()
+	v.changed.Broadcast()
+}
+
+// AddFrame publishes the board and, if the viewer is paused and the board is unstable, holds the
+// simulation until the viewer steps or resumes it.
+func (v *LiveView) AddFrame(b Board) error {
+
+	v.mu.Lock()
+	v.frame++
+	v.publish(b)
+	for !v.stopped && v.paused && v.steps == 0 && !v.stable {
+		v.changed.Wait()
+		v.publish(b)
+	}
+	if v.stopped {
+		v.mu.Unlock()
+		return errStopped
+	}
+	if v.steps > 0 {
+		v.steps--
+	}
+	paused := v.paused
+	v.mu.Unlock()
+
+	if !paused {
+		time.Sleep(v.Delay)
+	}
+	return nil
+}
+
+func (v *LiveView) Close() error {
+	return nil
+}
+
+// publish adds the grains dropped since the last frame to the simulated board b and copies it
+// for the viewer. It must be called with the lock held, while the simulation is between sweeps.
+func (v *LiveView) publish(b Board) {
+
+	for _, drop := range v.drops {
+		b[drop.row][drop.col] += drop.grains
+	}
+	v.drops = v.drops[:0]
+
+	for r := range b {
+		copy(v.board[r], b[r])
+	}
+	v.stable = boardStable(b, v.Lattice.Threshold())
+}
+
+// Handler returns the HTTP handler of the viewer: the page itself at /, the latest frame at
+// /frame.png, the state of the simulation as JSON at /state, and the controls /pause, /resume,
+// /step and /drop?row=&col=&grains=, which only accept POST requests.
+func (v *LiveView) Handler() http.Handler {
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, viewerPage)
+	})
+	mux.HandleFunc("/frame.png", v.serveFrame)
+	mux.HandleFunc("/state", v.serveState)
+	mux.HandleFunc("/pause", v.control(func() { v.paused = true }))
+	mux.HandleFunc("/resume", v.control(func() { v.paused = false }))
+	mux.HandleFunc("/step", v.control(func() {
+		v.paused = true
+		v.steps++
+	}))
+	mux.HandleFunc("/drop", v.serveDrop)
+	return mux
+}
+
+func (v *LiveView) serveFrame(w http.ResponseWriter, r *http.Request) {
+
+	// Drawing happens outside the lock on a copy, so a large board never holds up the simulation
+	v.mu.Lock()
+	board := copyBoard(v.board)
+	frame := v.frame
+	v.mu.Unlock()
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-store")
+	png.Encode(w, board.DrawFrame(v.Opts, frame))
+}
+
+func (v *LiveView) serveState(w http.ResponseWriter, r *http.Request) {
+
+	v.mu.Lock()
+	state := liveState{
+		Frame:     v.frame,
+		Rows:      len(v.board),
+		Cols:      len(v.board[0]),
+		CellWidth: v.Opts.CellWidth,
+		Paused:    v.paused,
+		Stable:    v.stable,
+	}
+	v.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(state)
+}
+
+func (v *LiveView) serveDrop(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "drop needs a POST request", http.StatusMethodNotAllowed)
+		return
+	}
+	row, err1 := strconv.Atoi(r.FormValue("row"))
+	col, err2 := strconv.Atoi(r.FormValue("col"))
+	grains, err3 := strconv.Atoi(r.FormValue("grains"))
+	if r.FormValue("grains") == "" {
+		grains, err3 = 1, nil
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if err1 != nil || err2 != nil || err3 != nil || grains <= 0 ||
+		row < 0 || row >= len(v.board) || col < 0 || col >= len(v.board[0]) {
+		http.Error(w, "drop needs a row and col on the board and a positive number of grains", http.StatusBadRequest)
+		return
+	}
+	if v.Opts.Mask.Inactive(row, col) {
+		http.Error(w, "grains can't be dropped outside the domain", http.StatusBadRequest)
+		return
+	}
+	// A closed lattice keeps every grain, so the latest frame and the drops still to come hold them all
+	if Closed(v.Lattice) {
+		total := boardTotal(v.board) + grains
+		for _, drop := range v.drops {
+			total += drop.grains
+		}
+		if total > len(v.board)*len(v.board[0])*(v.Lattice.Threshold()-1) {
+			http.Error(w, "the board can't hold that many grains and still stabilize on a closed lattice", http.StatusBadRequest)
+			return
+		}
+	}
+	v.drops = append(v.drops, grainDrop{row: row, col: col, grains: grains})
+	v.changed.Broadcast()
+}
+
+// control returns a handler that changes the state of the view with the lock held and wakes the simulation.
+func (v *LiveView) control(change func()) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "controls need a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+		v.mu.Lock()
+		change()
+		v.mu.Unlock()
+		v.changed.Broadcast()
+	}
+}
+
+// Input: a board and the lattice's toppling threshold
+// Output: true if no cell of the board holds threshold or more coins
+func boardStable(b Board, threshold int) bool {
+
+	for r := range b {
+		for _, val := range b[r] {
+			if val >= threshold {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// CheckLocalAddress takes the address a server should listen on.
+// It returns an error unless its host is localhost or a loopback IP, so the viewer is never
+// reachable from another machine.
+func CheckLocalAddress(addr string) error {
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return errors.New("serve only listens on localhost, not " + addr)
+}
+
+// viewerPage is the viewer: it polls /state, reloads the frame whenever it changes and turns
+// clicks on the board into drops.
+const viewerPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Sandpile live view</title>
+<style>
+body { font-family: sans-serif; background: #222; color: #eee; }
+img { image-rendering: pixelated; cursor: crosshair; max-width: 95vw; max-height: 80vh; }
+</style>
+</head>
+<body>
+<div>
+<button onclick="post('/pause')">Pause</button>
+<button onclick="post('/step')">Step</button>
+<button onclick="post('/resume')">Resume</button>
+grains per click <input id="grains" type="number" value="100" min="1" style="width: 6em">
+<span id="status"></span>
+</div>
+<img id="board" src="/frame.png">
+<script>
+let state = null;
+let shown = -1;
+const board = document.getElementById("board");
+
+function post(path) {
+	return fetch(path, {method: "POST"});
+}
+
+board.addEventListener("click", function (e) {
+	if (state === null) {
+		return;
+	}
+	const scale = board.naturalWidth / board.clientWidth;
+	const col = Math.floor(e.offsetX * scale / state.cellWidth);
+	const row = Math.floor(e.offsetY * scale / state.cellWidth);
+	if (row < state.rows && col < state.cols) {
+		const grains = document.getElementById("grains").value;
+		post("/drop?row=" + row + "&col=" + col + "&grains=" + grains);
+	}
+});
+
+async function poll() {
+	try {
+		state = await (await fetch("/state")).json();
+		document.getElementById("status").textContent = "frame " + state.frame +
+			(state.paused ? ", paused" : "") + (state.stable ? ", stable" : "");
+		if (state.frame !== shown) {
+			shown = state.frame;
+			board.src = "/frame.png?frame=" + shown;
+		}
+	} catch (err) {
+		document.getElementById("status").textContent = "server stopped";
+	}
+	setTimeout(poll, 150);
+}
+poll();
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"gifhelper"
+	"image"
+	"image/png"
+	"io"
+	"os"
+)
+
+// FrameSink receives the snapshots of a board as a simulation takes them, so a run never has to
+// keep every snapshot in memory. The board passed to AddFrame keeps changing after it returns,
+// so a sink must copy anything it wants to keep. Close is called once the run has finished.
+type FrameSink interface {
+	AddFrame(b Board) error
+	Close() error
+}
+
+// FrameList is a FrameSink that keeps a copy of every frame in memory.
+type FrameList struct {
+	Boards []Board
+}
+
+// GIFSink is a FrameSink that draws every frame as it arrives and writes them all to a GIF on Close.
+// Only the drawn images are kept, not the boards.
+type GIFSink struct {
+	filename string
+	opts     DrawOptions
+	next     int
+	images   []image.Image
+}
+
+// PNGSink is a FrameSink that writes every frame to its own numbered PNG file.
+type PNGSink struct {
+	prefix string
+	opts   DrawOptions
+	next   int
+}
+
+// BoardFileSink is a FrameSink that appends every frame to a compact binary board file,
+// which can be read back with ReadBoardFile. Each frame goes to the file in a single write,
+// so a run killed between frames never leaves half a frame behind.
+type BoardFileSink struct {
+	file *os.File
+}
+
+// MultiSink is a FrameSink that passes every frame to each of its sinks in order.
+type MultiSink []FrameSink
+
+// NewGIFSink takes the name of the GIF to write (without extension), the options to draw the frames
+// with and the number of the first frame, which the frame counter starts from.
+func NewGIFSink(filename string, opts DrawOptions, firstFrame int) *GIFSink {
+	return &GIFSink{filename: filename, opts: opts, next: firstFrame}
+}
+
+// NewPNGSink takes a file prefix, the options to draw the frames with and the number of the first frame.
+// Frames are written to prefix_00000.png, prefix_00001.png, ... starting from that number,
+// so a resumed run can carry on where the last one stopped.
+func NewPNGSink(prefix string, opts DrawOptions, firstFrame int) *PNGSink {
+	return &PNGSink{prefix: prefix, opts: opts, next: firstFrame}
+}
+
+// NewBoardFileSink takes a filename and the number of frames to keep from an existing file.
+// With no frames to keep the file is truncated. Otherwise everything after the first keepFrames
+// frames is cut off, so a resumed run drops the frames written after its checkpoint, and new
+// frames are appended.
+func NewBoardFileSink(filename string, keepFrames int) (*BoardFileSink, error) {
+
+	if keepFrames <= 0 {
+		file, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+		return &BoardFileSink{file: file}, nil
+	}
+
+	file, err := os.OpenFile(filename, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	offset, err := boardFileOffset(file, keepFrames)
+	if err == nil {
+		err = file.Truncate(offset)
+	}
+	if err == nil {
+		_, err = file.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("resuming %s: %w", filename, err)
+	}
+	return &BoardFileSink{file: file}, nil
+}
+
+func (f *FrameList) AddFrame(b Board) error {
+	f.Boards = append(f.Boards, copyBoard(b))
+	return nil
+}
+
+func (f *FrameList) Close() error {
+	return nil
+}
+
+func (g *GIFSink) AddFrame(b Board) error {
+	g.images = append(g.images, b.DrawFrame(g.opts, g.next))
+	g.next++
+	return nil
+}
+
+func (g *GIFSink) Close() error {
+	if len(g.images) == 0 {
+		return errors.New("no frames to write to " + g.filename)
+	}
+	gifhelper.ImagesToGIF(g.images, g.filename)
+	g.images = nil
+	return nil
+}
+
+func (p *PNGSink) AddFrame(b Board) error {
+
+	file, err := os.Create(fmt.Sprintf("%s_%05d.png", p.prefix, p.next))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	p.next++
+	return png.Encode(file, b.DrawFrame(p.opts, p.next-1))
+}
+
+func (p *PNGSink) Close() error {
+	return nil
+}
+
+func (s *BoardFileSink) AddFrame(b Board) error {
+	return writeBoardFrame(s.file, b)
+}
+
+func (s *BoardFileSink) Close() error {
+	return s.file.Close()
+}
+
+func (m MultiSink) AddFrame(b Board) error {
+	for _, sink := range m {
+		if err := sink.AddFrame(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes every sink, even if an earlier one fails, and returns the first error.
+func (m MultiSink) Close() error {
+	var firstErr error
+	for _, sink := range m {
+		if err := sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// NewFrameSink takes an output format (gif, png or board), the base name of the output, the options
+// to draw frames with and the number of frames an earlier run already wrote to the same output.
+// It returns the matching FrameSink. When resuming, the frames an interrupted run wrote after its
+// checkpoint are removed first, then PNG numbering carries on and board files are appended to; the GIF of an interrupted run was never written, so a new one holds the remaining frames.
+func NewFrameSink(format, output string, opts DrawOptions, framesWritten int) (FrameSink, error) {
+	switch format {
+	case "gif":
+		return NewGIFSink(output, opts, framesWritten), nil
+	case "png":
+		if err := removePNGFrames(output, framesWritten); err != nil {
+			return nil, err
+		}
+		return NewPNGSink(output, opts, framesWritten), nil
+	case "board":
+		return NewBoardFileSink(output+".sandpile", framesWritten)
+	}
+	return nil, errors.New("output format must be gif, png or board")
+}
+
+// Input: a writer and a board
+// Output: the board written as the number of rows and columns followed by every cell in row order,
+// all as unsigned varints so cells holding 0 to 127 coins take a single byte
+func writeBoardFrame(w io.Writer, b Board) error {
+
+	numCols := 0
+	if len(b) > 0 {
+		numCols = len(b[0])
+	}
+
+	buf := make([]byte, 0, binary.MaxVarintLen64*(2+len(b)*numCols))
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	buf = binary.AppendUvarint(buf, uint64(numCols))
+	for r := range b {
+		for c := range b[r] {
+			if b[r][c] < 0 {
+				return errors.New("can't write a board holding a negative number of coins")
+			}
+			buf = binary.AppendUvarint(buf, uint64(b[r][c]))
+		}
+	}
+	_, err := w.Write(buf)
+	return err
+}
+
+// Input: a reader positioned at the start of a frame written by writeBoardFrame
+// Output: the board, or io.EOF if the reader holds no more frames
+func readBoardFrame(r io.ByteReader) (Board, error) {
+
+	numRows, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	numCols, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	board := make(Board, numRows)
+	for i := range board {
+		board[i] = make([]int, numCols)
+		for j := range board[i] {
+			val, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, io.ErrUnexpectedEOF
+			}
+			board[i][j] = int(val)
+		}
+	}
+	return board, nil
+}
+
+// Input: a board file and a number of frames
+// Output: the number of bytes the first numFrames frames of the file take up, or an error if it holds fewer
+func boardFileOffset(file *os.File, numFrames int) (int64, error) {
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+	reader := &countingReader{r: bufio.NewReader(file)}
+	for i := 0; i < numFrames; i++ {
+		if _, err := readBoardFrame(reader); err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("holds %d frames, not the %d of the checkpoint", i, numFrames)
+			}
+			return 0, err
+		}
+	}
+	return reader.n, nil
+}
+
+// countingReader is an io.ByteReader that counts the bytes read through it.
+type countingReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// Input: the prefix of the PNG frames of a run and the number of frames to keep
+// Output: every frame file numbered first or higher removed, up to the first number with no file
+func removePNGFrames(prefix string, first int) error {
+
+	for i := first; ; i++ {
+		err := os.Remove(fmt.Sprintf("%s_%05d.png", prefix, i))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// ReadBoardFile takes the name of a file written by a BoardFileSink.
+// It returns every frame in the file in order.
+func ReadBoardFile(filename string) ([]Board, error) {
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	boards := make([]Board, 0)
+	for {
+		board, err := readBoardFrame(reader)
+		if err == io.EOF {
+			return boards, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reading frame %d of %s: %w", len(boards), filename, err)
+		}
+		boards = append(boards, board)
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+// SimulateSandpilesSparse takes as input a Board object, the lattice it topples on, a FrameSink and
+// an odometer board, which may be nil.
+// It topples the board in place to stability and passes only the input board and the final stable
+// board to the sink. Instead of sweeping the whole board, it keeps a queue of the unstable cells
+// and topples each one as many times as it can at once, so the work done only depends on the cells
+// an avalanche actually reaches. By the abelian property the final board and the odometer match
+// SimulateSandpiles.
+func SimulateSandpilesSparse(currentBoard Board, lattice Lattice, sink FrameSink, odometer Board) error {
+
+	if err := sink.AddFrame(currentBoard); err != nil {
+		return err
+	}
+	if err := toppleSparse(currentBoard, lattice, odometer); err != nil {
+		return err
+	}
+	return sink.AddFrame(currentBoard)
+}
+
+// Input: a board, the lattice it topples on and an odometer board, which may be nil
+// Output: the same board toppled in place until no cell holds the lattice's threshold or more coins,
+// with the number of topples of every cell added to the odometer, or errNeverStable once every cell
+// of a board on a closed lattice has toppled
+func toppleSparse(b Board, lattice Lattice, odometer Board) error {
+
+	if len(b) == 0 {
+		return nil
+	}
+
+	threshold := lattice.Threshold()
+	numRows := len(b)
+	numCols := len(b[0])
+
+	// queued marks the cells already waiting in the queue so no cell is added twice
+	queued := make([][]bool, numRows)
+	queue := make([][2]int, 0)
+	for r := range b {
+		queued[r] = make([]bool, numCols)
+		for c := range b[r] {
+			if b[r][c] >= threshold {
+				queued[r][c] = true
+				queue = append(queue, [2]int{r, c})
+			}
+		}
+	}
+
+	// On a closed lattice, the cells yet to topple are counted down as in checkNeverStable
+	var toppled [][]bool
+	unToppled := 0
+	if Closed(lattice) {
+		toppled = make([][]bool, numRows)
+		for r := range toppled {
+			toppled[r] = make([]bool, numCols)
+		}
+		unToppled = numRows * numCols
+	}
+
+	for len(queue) > 0 {
+		row, col := queue[0][0], queue[0][1]
+		queue = queue[1:]
+		queued[row][col] = false
+
+		// Mass topple: every neighbour gets one coin for each of the topples
+		numTopples := b[row][col] / threshold
+		if numTopples == 0 {
+			continue
+		}
+		b[row][col] -= numTopples * threshold
+		if odometer != nil {
+			odometer[row][col] += numTopples
+		}
+
+		for _, offset := range lattice.Neighbours(row, col) {
+			r, c, ok := lattice.Boundary(row+offset[0], col+offset[1], numRows, numCols)
+			if !ok {
+				continue
+			}
+			b[r][c] += numTopples
+			if b[r][c] >= threshold && !queued[r][c] {
+				queued[r][c] = true
+				queue = append(queue, [2]int{r, c})
+			}
+		}
+		if toppled != nil && !toppled[row][col] {
+			toppled[row][col] = true
+			unToppled--
+			if unToppled == 0 {
+				return errNeverStable
+			}
+		}
+	}
+	return nil
+}